@@ -0,0 +1,135 @@
+package dnscache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+)
+
+// refresher holds the runtime-adjustable auto-refresh settings of a Resolver, and serialises its Refresh passes.
+type refresher struct {
+	pass  sync.Mutex     // held for the duration of each pass
+	start sync.Once      // starts the auto-refresh goro
+	loop  sync.WaitGroup // the auto-refresh goro, for Close to wait for
+
+	lock     sync.Mutex
+	interval time.Duration
+	timeout  time.Duration
+	paused   bool
+
+	wake    chan struct{} // the settings changed
+	trigger chan struct{} // a pass is wanted now
+}
+
+// newRefresher returns a refresher with the initial interval and timeout.
+func newRefresher(interval, timeout time.Duration) *refresher {
+	return &refresher{
+		interval: interval,
+		timeout:  timeout,
+		wake:     make(chan struct{}, 1),
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// settings returns the current interval and timeout, and whether auto-refresh is paused.
+func (f *refresher) settings() (time.Duration, time.Duration, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.interval, f.timeout, f.paused
+}
+
+// update applies the change to the settings, and wakes the auto-refresh goro to notice it.
+func (f *refresher) update(change func()) {
+	f.lock.Lock()
+	change()
+	f.lock.Unlock()
+	signal(f.wake)
+}
+
+// signal sends on the channel without blocking. Signals already pending are coalesced.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// SetRefreshInterval changes the AutoRefreshInterval, starting the auto-refresh if it was not running.
+// The wait for the next pass is recalculated from when the last one finished. 0 stops auto-refresh passes,
+// other than those requested by TriggerRefresh.
+func (r *Resolver) SetRefreshInterval(interval time.Duration) {
+	r.refresher.update(func() { r.refresher.interval = interval })
+	r.startAutoRefresh()
+}
+
+// SetRefreshTimeout changes the AutoRefreshTimeout, from the next auto-refresh pass. 0 is no timeout.
+func (r *Resolver) SetRefreshTimeout(timeout time.Duration) {
+	r.refresher.update(func() { r.refresher.timeout = timeout })
+}
+
+// PauseRefresh pauses auto-refresh passes, until ResumeRefresh is called. A pass in progress is not interrupted,
+// and TriggerRefresh still requests one.
+func (r *Resolver) PauseRefresh() {
+	r.refresher.update(func() { r.refresher.paused = true })
+}
+
+// ResumeRefresh resumes auto-refresh passes paused by PauseRefresh. Any that came due while paused
+// is run immediately.
+func (r *Resolver) ResumeRefresh() {
+	r.refresher.update(func() { r.refresher.paused = false })
+}
+
+// TriggerRefresh requests an auto-refresh pass now, without waiting for the interval, and returns without
+// waiting for it. Requests made while a pass is in progress are coalesced into one pass after it.
+// The auto-refresh is started if it was not running.
+func (r *Resolver) TriggerRefresh() {
+	signal(r.refresher.trigger)
+	r.startAutoRefresh()
+}
+
+// startAutoRefresh starts the auto-refresh goro, unless it was already started, or the Resolver closed.
+func (r *Resolver) startAutoRefresh() {
+	r.refresher.start.Do(func() {
+		r.refresher.loop.Add(1)
+		go func() {
+			defer r.refresher.loop.Done()
+			r.autoRefresh()
+		}()
+	})
+}
+
+// stopAutoRefresh prevents the auto-refresh goro from starting, and waits for it to return if it had.
+// The done channel must already be closed, and any pass in progress cancelled.
+func (r *Resolver) stopAutoRefresh() {
+	r.refresher.start.Do(func() {})
+	r.refresher.loop.Wait()
+}
+
+// refreshResult makes a Refresh pass with the timeout, after any other pass made via the Resolver has finished,
+// so that manual and auto-refresh passes never overlap. The record caches with entries are refreshed after the cache,
+// within what is left of the timeout, but only the outcome of the cache is returned.
+func (r *Resolver) refreshResult(timeout time.Duration) (cache.RefreshResult, error) {
+	r.refresher.pass.Lock()
+	defer r.refresher.pass.Unlock()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	res, err := r.refreshCache(timeout)
+	r.records.refresh(deadline)
+	return res, err
+}
+
+// refreshCache makes a Refresh pass of the cache with the timeout. If the cache is not a cache.ResultCache,
+// only the Took of the RefreshResult is known.
+func (r *Resolver) refreshCache(timeout time.Duration) (cache.RefreshResult, error) {
+	if rc, ok := r.cache.(cache.ResultCache); ok {
+		return rc.RefreshResult(timeout)
+	}
+
+	start := time.Now()
+	r.cache.Refresh(timeout)
+	return cache.RefreshResult{Took: time.Since(start)}, nil
+}
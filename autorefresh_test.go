@@ -0,0 +1,160 @@
+package dnscache
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// passCache is a cache.Simple that counts its Refresh passes, and the most that were ever in progress at once.
+type passCache struct {
+	*cache.Simple
+
+	passes  atomic.Int32
+	lock    sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (p *passCache) RefreshResult(timeout time.Duration) (cache.RefreshResult, error) {
+	p.lock.Lock()
+	p.active++
+	p.maxSeen = max(p.maxSeen, p.active)
+	p.lock.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	defer func() {
+		p.lock.Lock()
+		p.active--
+		p.lock.Unlock()
+		p.passes.Add(1)
+	}()
+	return p.Simple.RefreshResult(timeout)
+}
+
+// newPassCache returns a passCache with no refresh sleep.
+func newPassCache() *passCache {
+	c, err := cache.NewSimple(cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0)))
+	So(err, ShouldBeNil)
+	return &passCache{Simple: c}
+}
+
+func TestTriggerRefresh(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a refresh is triggered, a pass is made without waiting for the interval.", t, func() {
+		c := newPassCache()
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		r.TriggerRefresh()
+		So(waitFor(func() bool { return c.passes.Load() == 1 }), ShouldBeTrue)
+
+		r.PauseRefresh() // triggers are still honored
+		r.TriggerRefresh()
+		So(waitFor(func() bool { return c.passes.Load() == 2 }), ShouldBeTrue)
+		time.Sleep(30 * time.Millisecond)
+		So(c.passes.Load(), ShouldEqual, 2)
+	})
+}
+
+func TestSetRefreshInterval(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When the refresh interval is changed, and refresh paused and resumed, passes follow suit.", t, func() {
+		c := newPassCache()
+		r := NewFromConfig(&ResolverConfig{
+			Cache:               c,
+			AutoRefreshInterval: time.Hour,
+		})
+		defer r.Close()
+
+		r.SetRefreshInterval(5 * time.Millisecond)
+		So(waitFor(func() bool { return c.passes.Load() >= 2 }), ShouldBeTrue)
+
+		r.PauseRefresh()
+		time.Sleep(20 * time.Millisecond) // any pass in progress finishes
+		paused := c.passes.Load()
+		time.Sleep(50 * time.Millisecond)
+		So(c.passes.Load(), ShouldEqual, paused)
+
+		r.ResumeRefresh()
+		So(waitFor(func() bool { return c.passes.Load() > paused }), ShouldBeTrue)
+
+		r.SetRefreshInterval(0)
+		time.Sleep(20 * time.Millisecond)
+		stopped := c.passes.Load()
+		time.Sleep(50 * time.Millisecond)
+		So(c.passes.Load(), ShouldEqual, stopped)
+	})
+
+	Convey("When the refresh interval is set on a Resolver without auto-refresh, it is started.", t, func() {
+		c := newPassCache()
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		r.SetRefreshTimeout(time.Second)
+		r.SetRefreshInterval(5 * time.Millisecond)
+		So(waitFor(func() bool { return c.passes.Load() >= 2 }), ShouldBeTrue)
+	})
+}
+
+func TestRefreshPassesDoNotOverlap(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When manual and auto-refresh passes are requested at once, they are made one at a time.", t, func() {
+		c := newPassCache()
+		r := NewFromConfig(&ResolverConfig{
+			Cache:               c,
+			AutoRefreshInterval: time.Millisecond,
+		})
+		defer r.Close()
+
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.Refresh()
+				r.TriggerRefresh()
+			}()
+		}
+		wg.Wait()
+		So(waitFor(func() bool { return c.passes.Load() >= 7 }), ShouldBeTrue)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		So(c.maxSeen, ShouldEqual, 1)
+	})
+}
+
+func TestCloseCancelsAutoRefresh(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Resolver is closed during an auto-refresh pass, the pass is cancelled, and waited for.", t, func() {
+		c, err := cache.NewSimple(cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Hour))
+		So(err, ShouldBeNil)
+		c.Add("1.localhost", []net.IP{net.ParseIP("127.0.0.1")})
+		c.Add("2.localhost", []net.IP{net.ParseIP("127.0.0.1")})
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		r.TriggerRefresh()
+		time.Sleep(20 * time.Millisecond) // into the pass's sleep
+
+		start := time.Now()
+		So(r.Close(), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
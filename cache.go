@@ -1,12 +1,17 @@
 package dnscache
 
 import (
+	"log/slog"
 	"net"
 	"time"
+
+	"github.com/cognusion/dnscache/cache"
 )
 
 // ResolverCache is an interface to define different caches for Resolver.
 // All functions defined here must be goro-safe.
+// Caches may also implement cache.ContextCache, cache.ResultCache, cache.StatsCache, cache.StaleCache,
+// cache.EntryCache, cache.ObservableCache, and cache.ExpiringCache, which the Resolver uses if so.
 type ResolverCache interface {
 	// Fetch retrieves a collection from the cache,
 	// or performs a live lookup and adds it to the cache.
@@ -22,6 +27,7 @@ type ResolverCache interface {
 	// intervals unless the cache mechanism exposes its own
 	// tunables.
 	// Refresh may honor RefreshShuffle if it is practical or desirable.
+	// Refresh must not panic.
 	Refresh(timeout time.Duration)
 	// Close should be used to signal end of operations.
 	// The cache should be considered unusable after this.
@@ -44,4 +50,38 @@ type ResolverConfig struct {
 	Cache               ResolverCache
 	AutoRefreshInterval time.Duration
 	AutoRefreshTimeout  time.Duration
+	// AutoRefreshJitter is the most that is added, at random, to each AutoRefreshInterval, so that
+	// processes started together don't refresh in lockstep. 0 disables jitter.
+	AutoRefreshJitter time.Duration
+	// Family filters, or orders, the IPs returned by the Fetch functions.
+	// The cache stores all of the resolved IPs regardless.
+	// The zero value is cache.FamilyAny.
+	Family cache.Family
+	// Selection is how FetchOne, and FetchOneString, choose one IP from many.
+	// The zero value is SelectFirst. Unsupported values are logged, and SelectFirst used.
+	Selection Selection
+	// Weights is used by SelectWeightedRandom. If nil, all IPs are weighed equally.
+	Weights WeightFunc
+	// Quarantine is how long an IP is avoided after its first ReportFailure. Defaults to 5s.
+	Quarantine time.Duration
+	// QuarantineMax is the longest an IP is avoided after consecutive ReportFailures. Defaults to 5m.
+	QuarantineMax time.Duration
+	// Logger is used to log auto-refresh passes, quarantines, and failed dials. If nil, nothing is logged.
+	// If Cache is nil, the default cache uses it too. Otherwise, configure the cache via cache.ConfigLogger.
+	Logger *slog.Logger
+	// RecordOptions configure the caches of LookupSRV, LookupTXT, LookupMX, LookupCNAME, and LookupAddr, as
+	// for cache.NewCache. Each cache is instantiated on first use. Entries are refreshed after those of the
+	// Cache, within the same timeout. Defaults to the RefreshSleepTime, RefreshShuffle, and Logger of the
+	// Resolver. If invalid, the error is logged by NewFromConfig, and the defaults used.
+	RecordOptions []cache.ConfigOption
+	// SRVResolver resolves the records of LookupSRV. Defaults to cache.DefaultSRVResolver.
+	SRVResolver cache.ValueResolverFunc[[]*net.SRV]
+	// TXTResolver resolves the records of LookupTXT. Defaults to cache.DefaultTXTResolver.
+	TXTResolver cache.ValueResolverFunc[[]string]
+	// MXResolver resolves the records of LookupMX. Defaults to cache.DefaultMXResolver.
+	MXResolver cache.ValueResolverFunc[[]*net.MX]
+	// CNAMEResolver resolves the names of LookupCNAME. Defaults to cache.DefaultCNAMEResolver.
+	CNAMEResolver cache.ValueResolverFunc[string]
+	// AddrResolver resolves the names of LookupAddr. Defaults to cache.DefaultAddrResolver.
+	AddrResolver cache.ValueResolverFunc[[]string]
 }
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// closer tracks the Refresh passes, and their lookups, in progress, so that Close can cancel them,
+// and wait for them to finish.
+type closer struct {
+	ctx    context.Context // cancelled by close
+	cancel context.CancelFunc
+
+	lock   sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newCloser returns a closer.
+func newCloser() *closer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &closer{ctx: ctx, cancel: cancel}
+}
+
+// enter returns true, counting an operation in progress until exit is called, or false if closed.
+func (c *closer) enter() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
+
+// exit ends an operation counted by enter.
+func (c *closer) exit() {
+	c.wg.Done()
+}
+
+// close cancels the Context, and waits for the operations in progress to exit. No more may enter.
+// It is safe to call more than once.
+func (c *closer) close() {
+	c.lock.Lock()
+	c.closed = true
+	c.lock.Unlock()
+
+	c.cancel()
+	c.wg.Wait()
+}
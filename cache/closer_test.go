@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CloseCancelsRefresh(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a cache is closed during a LinearRefresh, the pass ends promptly with the cancellation", t, func() {
+		var lookups lookupLog
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshSleepTime, time.Hour),
+		)
+		So(err, ShouldBeNil)
+
+		for i := range 3 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP("127.0.0.1")})
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := c.RefreshResult(0)
+			errs <- err
+		}()
+		time.Sleep(10 * time.Millisecond) // into the first sleep
+
+		start := time.Now()
+		So(c.Close(), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(errors.Is(<-errs, context.Canceled), ShouldBeTrue)
+	})
+
+	Convey("When a cache is closed during a BatchRefresh, the lookups in flight are cancelled, and waited for", t, func() {
+		fake := fakeResolver{block: true}
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshType, RefreshBatch),
+			NewConfigOption(ConfigRefreshBatchSize, 5),
+		)
+		So(err, ShouldBeNil)
+
+		for i := range 6 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP("127.0.0.1")})
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := c.RefreshResult(0)
+			errs <- err
+		}()
+		So(waitFor(func() bool { return fake.active.Load() == 5 }), ShouldBeTrue)
+
+		So(c.Close(), ShouldBeNil)
+		So(fake.active.Load(), ShouldBeZeroValue)
+		So(errors.Is(<-errs, context.Canceled), ShouldBeTrue)
+	})
+
+	Convey("When a Refresh times out, its lookups are not cancelled", t, func() {
+		fake := fakeResolver{block: true}
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshType, RefreshBatch),
+			NewConfigOption(ConfigRefreshBatchSize, 1),
+		)
+		So(err, ShouldBeNil)
+
+		for i := range 2 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP("127.0.0.1")})
+		}
+
+		res, err := c.RefreshResult(10 * time.Millisecond)
+		So(err, ShouldBeNil)
+		So(res.Completed, ShouldBeFalse)
+		So(fake.active.Load(), ShouldEqual, 1)
+
+		So(c.Close(), ShouldBeNil)
+		So(fake.active.Load(), ShouldBeZeroValue)
+	})
+
+	Convey("When a cache is closed, Refreshes are refused, and it may be closed again", t, func() {
+		for _, c := range entryCaches() {
+			So(c.Close(), ShouldBeNil)
+			_, err := c.RefreshResult(0)
+			So(err, ShouldEqual, ErrorClosed)
+			c.Refresh(0)
+			So(c.Close(), ShouldBeNil)
+		}
+	})
+}
@@ -3,29 +3,69 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"slices"
+	"time"
 )
 
 var (
 	// ErrorConfigKeyUnsupported is returned by cache constructors when a ConfigOption passed is unsupported.
 	ErrorConfigKeyUnsupported = errors.New("option is not supported")
 
-	// DefaultResolver is the resolver that will be used if nothing is passed to a constructor.
+	// ErrorClosed is returned by RefreshResult when the cache has been closed.
+	ErrorClosed = errors.New("cache is closed")
+
+	// DefaultResolver is the context-less resolver, retained for compatibility.
+	// If it has been replaced, constructors use it instead of DefaultResolverContext, ignoring the Context.
+	//
+	// Deprecated: Override DefaultResolverContext instead, or pass a ConfigResolver to the constructor.
 	DefaultResolver ResolverFunc = net.LookupIP
+
+	// DefaultResolverContext is the resolver that will be used if nothing is passed to a constructor,
+	// and DefaultResolver has not been replaced. Changes after a cache is instantiated are ignored by it.
+	DefaultResolverContext ResolverContextFunc = func(ctx context.Context, address string) ([]net.IP, error) {
+		return net.DefaultResolver.LookupIP(ctx, "ip", address)
+	}
+)
+
+const (
+	// FamilyAny is a Family for IPs of any address family, in the order they were resolved.
+	FamilyAny = Family("")
+	// FamilyIPv4 is a Family for IPv4 addresses only.
+	FamilyIPv4 = Family("IPv4")
+	// FamilyIPv6 is a Family for IPv6 addresses only.
+	FamilyIPv6 = Family("IPv6")
+	// FamilyPreferIPv4 is a Family for IPs of any address family, IPv4 addresses first.
+	FamilyPreferIPv4 = Family("PreferIPv4")
+	// FamilyPreferIPv6 is a Family for IPs of any address family, IPv6 addresses first.
+	FamilyPreferIPv6 = Family("PreferIPv6")
 )
 
 // RefreshType is a string type for static consistency
 type RefreshType string
 
+// Family is a string type for static consistency
+type Family string
+
 // RefreshableCache is a minimal interface that caches must implement to be Refreshable.
+// RefreshFuncs may also check for EntryCache, to use the metadata of the entries.
 type RefreshableCache interface {
 	Keys() []string
 	Contains(address string) bool
 }
 
+// ContextCache is an interface that caches whose live lookups honor a Context implement.
+type ContextCache interface {
+	// FetchContext is Fetch, but any live lookup honors the Context.
+	FetchContext(ctx context.Context, address string) ([]net.IP, error)
+	// LookupContext is Lookup, but honors the Context.
+	LookupContext(ctx context.Context, address string) ([]net.IP, error)
+}
+
 // RefreshFunc is a definition for a Refreshable Refresh. How refreshing!
 // Do you feel refreshed? How many more times will I say "refresh"?
 // Refresh.
@@ -34,6 +74,46 @@ type RefreshFunc func(RefreshableCache, ResolverFunc, ...ConfigOption) (bool, er
 // ResolverFunc is a type to allow abtracting of the lowest resolver logic.
 type ResolverFunc func(address string) ([]net.IP, error)
 
+// ResolverContextFunc is a ResolverFunc that honors the deadline and cancellation of the
+// provided Context.
+type ResolverContextFunc func(ctx context.Context, address string) ([]net.IP, error)
+
+// toResolverTTLFunc accepts a ResolverFunc, a ResolverContextFunc, or a ResolverTTLFunc, returning
+// a ResolverTTLFunc and true, or nil and false if the value is none of those.
+// A ResolverFunc is wrapped, and will ignore the Context.
+// Wrapped ResolverFuncs and ResolverContextFuncs always return TTLUnknown.
+func toResolverTTLFunc(v any) (ResolverTTLFunc, bool) {
+	switch f := v.(type) {
+	case ResolverTTLFunc:
+		return f, true
+	case ResolverContextFunc:
+		return withUnknownTTL(f), true
+	case ResolverFunc:
+		return withUnknownTTL(func(_ context.Context, address string) ([]net.IP, error) {
+			return f(address)
+		}), true
+	}
+	return nil, false
+}
+
+// defaultResolver returns the resolver constructors use if no ConfigResolver is passed: DefaultResolver,
+// if it has been replaced, otherwise DefaultResolverContext.
+func defaultResolver() ResolverTTLFunc {
+	if DefaultResolver != nil && reflect.ValueOf(DefaultResolver).Pointer() != reflect.ValueOf(net.LookupIP).Pointer() {
+		f, _ := toResolverTTLFunc(DefaultResolver)
+		return f
+	}
+	return withUnknownTTL(DefaultResolverContext)
+}
+
+// withUnknownTTL wraps a ResolverContextFunc as a ResolverTTLFunc that always returns TTLUnknown.
+func withUnknownTTL(f ResolverContextFunc) ResolverTTLFunc {
+	return func(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+		ips, err := f(ctx, address)
+		return ips, TTLUnknown, err
+	}
+}
+
 // ConfigKey is a string type for static config key name consistency
 type ConfigKey string
 
@@ -67,6 +147,34 @@ func NewConfigOption(key ConfigKey, value any) ConfigOption {
 	}
 }
 
+// FilterIPs returns a new slice of those IPs that are of the specified Family, or for
+// FamilyPreferIPv4 and FamilyPreferIPv6, all of the IPs, with those of the preferred family first.
+// Order within each family is preserved. FamilyAny, or an unknown Family, returns a copy of the IPs.
+func FilterIPs(family Family, ips ...net.IP) []net.IP {
+	filtered := make([]net.IP, 0, len(ips))
+	switch family {
+	case FamilyIPv4, FamilyIPv6:
+		for _, i := range ips {
+			if (i.To4() != nil) == (family == FamilyIPv4) {
+				filtered = append(filtered, i)
+			}
+		}
+	case FamilyPreferIPv4, FamilyPreferIPv6:
+		var rest []net.IP
+		for _, i := range ips {
+			if (i.To4() != nil) == (family == FamilyPreferIPv4) {
+				filtered = append(filtered, i)
+			} else {
+				rest = append(rest, i)
+			}
+		}
+		filtered = append(filtered, rest...)
+	default:
+		filtered = append(filtered, ips...)
+	}
+	return filtered
+}
+
 // ipsTov4 takes a list of net.IPs and returns a []string of those that are valid ipv4s.
 func ipsTov4(ips ...net.IP) []string {
 	ip4s := make([]string, 0)
@@ -79,3 +187,23 @@ func ipsTov4(ips ...net.IP) []string {
 	slices.Sort(ip4s)
 	return ip4s
 }
+
+// SameIPs returns true if the collections contain the same IPs, in any order.
+func SameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, ip := range a {
+		counts[string(ip.To16())]++
+	}
+	for _, ip := range b {
+		k := string(ip.To16())
+		if counts[k] == 0 {
+			return false
+		}
+		counts[k]--
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_FilterIPs(t *testing.T) {
+	Convey("When IPs are filtered by Family, the expected IPs are returned, in the expected order", t, func() {
+		var (
+			v6a = net.ParseIP("2001:4860:4860::8888")
+			v4a = net.ParseIP("8.8.8.8")
+			v6b = net.ParseIP("2001:4860:4860::8844")
+			v4b = net.ParseIP("8.8.4.4")
+			ips = []net.IP{v6a, v4a, v6b, v4b}
+		)
+
+		So(FilterIPs(FamilyAny, ips...), ShouldResemble, ips)
+		So(FilterIPs(FamilyIPv4, ips...), ShouldResemble, []net.IP{v4a, v4b})
+		So(FilterIPs(FamilyIPv6, ips...), ShouldResemble, []net.IP{v6a, v6b})
+		So(FilterIPs(FamilyPreferIPv4, ips...), ShouldResemble, []net.IP{v4a, v4b, v6a, v6b})
+		So(FilterIPs(FamilyPreferIPv6, ips...), ShouldResemble, []net.IP{v6a, v6b, v4a, v4b})
+		So(FilterIPs(FamilyIPv4, v6a), ShouldBeEmpty)
+
+		Convey("and the original collection is untouched", func() {
+			So(ips, ShouldResemble, []net.IP{v6a, v4a, v6b, v4b})
+		})
+	})
+}
+
+func Test_SameIPs(t *testing.T) {
+	Convey("When collections of IPs are compared, order is ignored, but counts are not", t, func() {
+		var (
+			a = net.ParseIP("8.8.8.8")
+			b = net.ParseIP("8.8.4.4")
+			c = net.ParseIP("2001:4860:4860::8888")
+		)
+
+		So(SameIPs(nil, []net.IP{}), ShouldBeTrue)
+		So(SameIPs([]net.IP{a, b, c}, []net.IP{c, a, b}), ShouldBeTrue)
+		So(SameIPs([]net.IP{a, b}, []net.IP{a, c}), ShouldBeFalse)
+		So(SameIPs([]net.IP{a, a}, []net.IP{a, b}), ShouldBeFalse)
+		So(SameIPs([]net.IP{a}, []net.IP{a.To4()}), ShouldBeTrue)
+		So(SameIPs([]net.IP{a}, []net.IP{a, b}), ShouldBeFalse)
+	})
+}
+
+func Test_DefaultResolver(t *testing.T) {
+	Convey("When DefaultResolver is replaced, the constructors use it instead of DefaultResolverContext", t, func() {
+		var calls, contextCalls atomic.Int32
+		resolver, contextResolver := DefaultResolver, DefaultResolverContext
+		defer func() { DefaultResolver, DefaultResolverContext = resolver, contextResolver }()
+		DefaultResolver = func(address string) ([]net.IP, error) {
+			calls.Add(1)
+			return []net.IP{net.ParseIP("8.8.8.8")}, nil
+		}
+		DefaultResolverContext = func(ctx context.Context, address string) ([]net.IP, error) {
+			contextCalls.Add(1)
+			return []net.IP{net.ParseIP("8.8.4.4")}, nil
+		}
+
+		s, err := NewSimple()
+		So(err, ShouldBeNil)
+		defer s.Close()
+		l, err := NewLRU(NewConfigOption(ConfigSize, 2))
+		So(err, ShouldBeNil)
+		defer l.Close()
+
+		ips, _ := s.Fetch("dns.google.com")
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("8.8.8.8")})
+		ips, _ = l.Fetch("dns.google.com")
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("8.8.8.8")})
+		So(calls.Load(), ShouldEqual, 2)
+		So(contextCalls.Load(), ShouldEqual, 0)
+
+		Convey("and when it is not, DefaultResolverContext", func() {
+			DefaultResolver = resolver
+
+			s, err := NewSimple()
+			So(err, ShouldBeNil)
+			defer s.Close()
+
+			ips, _ := s.Fetch("dns.google.com")
+			So(ips, ShouldResemble, []net.IP{net.ParseIP("8.8.4.4")})
+			So(contextCalls.Load(), ShouldEqual, 1)
+		})
+	})
+}
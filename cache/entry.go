@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ConfigResolverName is a string.
+	// This names the Resolver, and is the Source of the entries it produces.
+	// Defaults to "Resolver".
+	ConfigResolverName = ConfigKey("ResolverName")
+
+	// SourceAdd is the Source of entries that were Added, rather than looked up.
+	SourceAdd = "Add"
+)
+
+// EntryCache is an interface that caches which keep Entry metadata implement.
+// RefreshFuncs may use it, if the RefreshableCache they are passed implements it.
+type EntryCache interface {
+	// GetEntry returns the Entry for the address, and true, or false if there is none.
+	// The recency and hit count of the entry are not updated.
+	GetEntry(address string) (Entry, bool)
+}
+
+// getEntry returns the Entry for the address, and true, if the cache is an EntryCache that has one.
+// RefreshableCache wrappers use this to forward GetEntry.
+func getEntry(cache RefreshableCache, address string) (Entry, bool) {
+	if ec, ok := cache.(EntryCache); ok {
+		return ec.GetEntry(address)
+	}
+	return Entry{}, false
+}
+
+// Entry is a snapshot of a cached collection and its metadata.
+type Entry struct {
+	// IPs is the collection.
+	IPs []net.IP
+	// Inserted is when the address was first cached. Refreshes do not change it.
+	Inserted time.Time
+	// Refreshed is when the collection was last set, by a lookup or an Add.
+	Refreshed time.Time
+	// Expires is when the collection expires, or the zero Time if it does not.
+	Expires time.Time
+	// Hits is the number of Fetches served the collection since the address was inserted.
+	Hits uint64
+	// RecentHits is the number of those Hits since the last completed Refresh pass.
+	RecentHits uint64
+	// IdlePasses is the number of consecutive completed Refresh passes, up to the last, during which there were no Hits.
+	IdlePasses int
+	// Source is the name of the Resolver that produced the collection, or SourceAdd.
+	Source string
+	// LastError is the error of the last lookup of the address, if it failed, or nil.
+	LastError error
+}
+
+// entry is a cached collection, and its bookkeeping.
+type entry struct {
+	ips       []net.IP
+	value     any       // of a Cache, whose entries have no IPs
+	expires   time.Time // zero never expires
+	refreshed time.Time
+	source    string
+	meta      *entryMeta // shared by copies, and carried across refreshes
+}
+
+// entryMeta is the mutable metadata of an entry.
+type entryMeta struct {
+	inserted time.Time
+	hits     atomic.Uint64
+	recent   atomic.Uint64 // hits since the last completed Refresh pass
+	idle     atomic.Int64  // consecutive completed Refresh passes without hits
+	lastErr  atomic.Pointer[error]
+}
+
+// expired returns true if the entry has an expiry, and it is before now.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// due returns true if the entry is due a Refresh in a cache honoring record TTLs: it has expired,
+// or it has no expiry, so its TTL was unknown, and it is refreshed by every pass.
+func (e *entry) due(now time.Time) bool {
+	return e.expires.IsZero() || e.expired(now)
+}
+
+// carry sets the metadata of the entry to that of the old one it is replacing, if it existed,
+// or new metadata otherwise. Any last error is cleared.
+func (e *entry) carry(old entry, existed bool) {
+	if existed && old.meta != nil {
+		e.meta = old.meta
+		e.meta.lastErr.Store(nil)
+		return
+	}
+	e.meta = &entryMeta{inserted: e.refreshed}
+}
+
+// hit counts a hit on the entry.
+func (e *entry) hit() {
+	if e.meta != nil {
+		e.meta.hits.Add(1)
+		e.meta.recent.Add(1)
+	}
+}
+
+// failed records the error of a failed lookup of the entry.
+func (e *entry) failed(err error) {
+	if e.meta != nil {
+		e.meta.lastErr.Store(&err)
+	}
+}
+
+// export returns the Entry of the entry.
+func (e *entry) export() Entry {
+	x := Entry{
+		IPs:       e.ips,
+		Refreshed: e.refreshed,
+		Expires:   e.expires,
+		Source:    e.source,
+	}
+	if e.meta != nil {
+		x.Inserted = e.meta.inserted
+		x.Hits = e.meta.hits.Load()
+		x.RecentHits = e.meta.recent.Load()
+		x.IdlePasses = int(e.meta.idle.Load())
+		if err := e.meta.lastErr.Load(); err != nil {
+			x.LastError = *err
+		}
+	}
+	return x
+}
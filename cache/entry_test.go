@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// entryCache is the part of Simple and LRU the entry tests use.
+type entryCache interface {
+	EntryCache
+	Fetch(address string) ([]net.IP, error)
+	Refresh(timeout time.Duration)
+	RefreshResult(timeout time.Duration) (RefreshResult, error)
+	Add(address string, ips []net.IP)
+	Remove(address string)
+	Close() error
+}
+
+// entryCaches returns a Simple and an LRU, with the options, for tests of both.
+func entryCaches(options ...ConfigOption) map[string]entryCache {
+	s, err := NewSimple(options...)
+	So(err, ShouldBeNil)
+	l, err := NewLRU(append(options, NewConfigOption(ConfigSize, 10))...)
+	So(err, ShouldBeNil)
+
+	return map[string]entryCache{"Simple": s, "LRU": l}
+}
+
+func Test_GetEntry(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When entries are looked up, fetched, and refreshed, their metadata is kept", t, func() {
+		fake := fakeResolver{ttl: TTLUnknown}
+
+		for _, c := range entryCaches(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigResolverName, "upstream"),
+		) {
+			defer c.Close()
+			fake.answer.Store("8.8.8.8")
+
+			_, ok := c.GetEntry("dns.google.com")
+			So(ok, ShouldBeFalse)
+
+			before := time.Now()
+			c.Fetch("dns.google.com") // miss
+			c.Fetch("dns.google.com") // hit
+			c.Fetch("dns.google.com") // hit
+
+			e, ok := c.GetEntry("dns.google.com")
+			So(ok, ShouldBeTrue)
+			So(e.IPs, ShouldResemble, []net.IP{net.ParseIP("8.8.8.8")})
+			So(e.Source, ShouldEqual, "upstream")
+			So(e.Hits, ShouldEqual, 2)
+			So(e.Inserted, ShouldHappenOnOrAfter, before)
+			So(e.Refreshed, ShouldEqual, e.Inserted)
+			So(e.Expires.IsZero(), ShouldBeTrue)
+			So(e.LastError, ShouldBeNil)
+
+			c.GetEntry("dns.google.com")
+			e, _ = c.GetEntry("dns.google.com")
+			So(e.Hits, ShouldEqual, 2) // GetEntry is not a hit
+
+			time.Sleep(time.Millisecond)
+			fake.fail.Store(true)
+			c.Refresh(0)
+
+			e, ok = c.GetEntry("dns.google.com")
+			So(ok, ShouldBeTrue)
+			So(e.IPs, ShouldResemble, []net.IP{net.ParseIP("8.8.8.8")})
+			So(e.LastError, ShouldNotBeNil)
+
+			fake.fail.Store(false)
+			fake.answer.Store("8.8.4.4")
+			c.Refresh(0)
+
+			r, ok := c.GetEntry("dns.google.com")
+			So(ok, ShouldBeTrue)
+			So(r.IPs, ShouldResemble, []net.IP{net.ParseIP("8.8.4.4")})
+			So(r.LastError, ShouldBeNil)
+			So(r.Hits, ShouldEqual, 2)
+			So(r.Inserted, ShouldEqual, e.Inserted)
+			So(r.Refreshed, ShouldHappenAfter, e.Refreshed)
+
+			c.Add("localhost", []net.IP{net.ParseIP("127.0.0.1")})
+			e, ok = c.GetEntry("localhost")
+			So(ok, ShouldBeTrue)
+			So(e.Source, ShouldEqual, SourceAdd)
+			So(e.Hits, ShouldBeZeroValue)
+
+			c.Remove("localhost")
+			c.Add("localhost", []net.IP{net.ParseIP("127.0.0.1")})
+			r, _ = c.GetEntry("localhost")
+			So(r.Inserted, ShouldHappenOnOrAfter, e.Inserted) // re-inserted, not carried
+		}
+	})
+
+	Convey("When a RefreshFunc is passed the cache, it can get the entries", t, func() {
+		var (
+			fake = fakeResolver{ttl: TTLUnknown}
+			hits = make(map[string]uint64)
+		)
+		fake.answer.Store("8.8.8.8")
+
+		refresh := func(cache RefreshableCache, resolver ResolverFunc, options ...ConfigOption) (bool, error) {
+			// not cache.Keys(), as entries without a record TTL never expire
+			if e, ok := getEntry(cache, "localhost"); ok {
+				hits["localhost"] = e.Hits
+			}
+			return true, nil
+		}
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRecordTTL, true), // wraps the cache in a dueCache, too
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		c.refresh = refresh
+
+		c.Add("localhost", []net.IP{net.ParseIP("127.0.0.1")})
+		c.Fetch("localhost")
+		c.Refresh(0)
+		So(hits, ShouldResemble, map[string]uint64{"localhost": 1})
+	})
+
+	Convey("When a ResolverName is not a string, an error is returned", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigResolverName, 42))
+		So(err, ShouldEqual, ConfigResolverName.Error())
+
+		_, err = NewLRU(NewConfigOption(ConfigSize, 10), NewConfigOption(ConfigResolverName, 42))
+		So(err, ShouldEqual, ConfigResolverName.Error())
+	})
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// call is an in-flight, or just-completed, lookup shared by one or more callers.
+type call[T any] struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	val T
+	err error
+}
+
+// flightGroup coalesces concurrent lookups of the same address into a single resolver call.
+// The zero value is ready to use.
+type flightGroup[T any] struct {
+	lock  sync.Mutex
+	calls map[string]*call[T]
+}
+
+// do executes fn for the address, unless a call for the address is already in flight, in which
+// case the caller waits for, and receives, the result of that call instead.
+// Each caller may abandon the wait via its own Context. The Context passed to fn carries the values
+// of the first caller's Context, but not its deadline, as a later caller may be willing to wait longer,
+// and is only canceled once every caller has abandoned the call. Callers arriving after that start a
+// new call.
+func (g *flightGroup[T]) do(ctx context.Context, address string, fn func(context.Context) (T, error)) (T, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	c, ok := g.calls[address]
+	if !ok {
+		fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[T]{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		g.calls[address] = c
+
+		go func() {
+			c.val, c.err = fn(fctx)
+
+			g.lock.Lock()
+			g.forget(address, c)
+			g.lock.Unlock()
+
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody cares anymore, and nobody else should join it
+			g.forget(address, c)
+			c.cancel()
+		}
+		g.lock.Unlock()
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// forget removes the call for the address, if it is still the one in flight.
+// The lock must be held.
+func (g *flightGroup[T]) forget(address string, c *call[T]) {
+	if g.calls[address] == c {
+		delete(g.calls, address)
+	}
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_FlightGroupCoalesces(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When many callers ask for the same address concurrently, there is one call and everyone gets its result", t, func() {
+		var (
+			g       flightGroup[[]net.IP]
+			calls   atomic.Int32
+			release = make(chan struct{})
+			wg      sync.WaitGroup
+			errBoom = errors.New("boom")
+		)
+
+		fn := func(context.Context) ([]net.IP, error) {
+			calls.Add(1)
+			<-release
+			return nil, errBoom
+		}
+
+		errs := make([]error, 50)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = g.do(context.Background(), "dns.google.com", fn)
+			}()
+		}
+		time.Sleep(10 * time.Millisecond) // let them pile up
+		close(release)
+		wg.Wait()
+
+		So(calls.Load(), ShouldEqual, 1)
+		for _, e := range errs {
+			So(e, ShouldEqual, errBoom)
+		}
+	})
+}
+
+func Test_FlightGroupAbandon(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When one of two waiters abandons a call, the call continues for the other", t, func() {
+		var (
+			g       flightGroup[[]net.IP]
+			release = make(chan struct{})
+		)
+
+		fn := func(ctx context.Context) ([]net.IP, error) {
+			select {
+			case <-release:
+				return []net.IP{net.ParseIP("8.8.8.8")}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error)
+		go func() {
+			_, err := g.do(context.Background(), "dns.google.com", fn)
+			result <- err
+		}()
+		time.Sleep(5 * time.Millisecond)
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+		}()
+		_, err := g.do(ctx, "dns.google.com", fn)
+		So(err, ShouldEqual, context.Canceled)
+
+		close(release)
+		So(<-result, ShouldBeNil)
+	})
+
+	Convey("When the only waiter abandons a call, the call is canceled", t, func() {
+		var g flightGroup[[]net.IP]
+
+		canceled := make(chan struct{})
+		fn := func(ctx context.Context) ([]net.IP, error) {
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		_, err := g.do(ctx, "dns.google.com", fn)
+		So(err, ShouldEqual, context.DeadlineExceeded)
+
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			So("the call was not canceled", ShouldBeEmpty)
+		}
+	})
+}
+
+func Test_FlightGroupRejoin(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a caller arrives after every waiter abandoned a call, it starts a new call", t, func() {
+		var (
+			g       flightGroup[[]net.IP]
+			calls   atomic.Int32
+			release = make(chan struct{})
+		)
+
+		fn := func(ctx context.Context) ([]net.IP, error) {
+			if calls.Add(1) == 1 {
+				<-release // slow to notice it was canceled
+				return nil, ctx.Err()
+			}
+			return []net.IP{net.ParseIP("8.8.8.8")}, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(5*time.Millisecond, cancel)
+		_, err := g.do(ctx, "dns.google.com", fn)
+		So(err, ShouldEqual, context.Canceled)
+
+		ips, err := g.do(context.Background(), "dns.google.com", fn)
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 1)
+		So(calls.Load(), ShouldEqual, 2)
+
+		close(release)
+		time.Sleep(5 * time.Millisecond) // the first call finishes
+		g.lock.Lock()
+		So(g.calls, ShouldBeEmpty)
+		g.lock.Unlock()
+	})
+}
+
+func Test_FlightGroupDeadline(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When the first caller has a deadline, the call does not, so later callers may wait longer", t, func() {
+		var (
+			g       flightGroup[[]net.IP]
+			ok      atomic.Bool
+			started = make(chan struct{})
+			release = make(chan struct{})
+		)
+		fn := func(ctx context.Context) ([]net.IP, error) {
+			_, deadline := ctx.Deadline()
+			ok.Store(deadline)
+			close(started)
+			select {
+			case <-release:
+				return []net.IP{net.ParseIP("8.8.8.8")}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		first := make(chan error, 1)
+		go func() {
+			_, err := g.do(ctx, "dns.google.com", fn)
+			first <- err
+		}()
+		<-started
+
+		later := make(chan error, 1)
+		go func() {
+			_, err := g.do(context.Background(), "dns.google.com", fn)
+			later <- err
+		}()
+
+		So(waitFor(func() bool {
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			return g.calls["dns.google.com"].waiters == 2
+		}), ShouldBeTrue)
+		cancel() // as if its deadline had passed
+
+		So(<-first, ShouldEqual, context.Canceled)
+		close(release)
+		So(<-later, ShouldBeNil)
+		So(ok.Load(), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// fakeResolver is the resolver of tests. It counts its lookups, and those in progress, and answers
+// with the IP stored in answer, or 8.8.8.8 if none is, and the ttl. While fail is true, it fails with
+// err, or "boom" if that is nil. If block is true, it waits for the Context to be done instead.
+type fakeResolver struct {
+	ttl   time.Duration
+	err   error
+	block bool
+
+	calls  atomic.Int32
+	active atomic.Int32
+	fail   atomic.Bool
+	answer atomic.Value // string
+}
+
+// resolve is the ResolverTTLFunc of the fakeResolver.
+func (f *fakeResolver) resolve(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+	f.calls.Add(1)
+	f.active.Add(1)
+	defer f.active.Add(-1)
+
+	switch {
+	case f.block:
+		<-ctx.Done()
+		return nil, TTLUnknown, ctx.Err()
+	case f.fail.Load():
+		return nil, TTLUnknown, cmp.Or(f.err, errors.New("boom"))
+	}
+	answer, _ := f.answer.Load().(string)
+	return []net.IP{net.ParseIP(cmp.Or(answer, "8.8.8.8"))}, f.ttl, nil
+}
+
+// waitFor polls the condition until it is true, or a second has passed, returning the last result.
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !condition() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return condition()
+}
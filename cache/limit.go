@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ConfigRefreshRate is a float64.
+	// For values > 0, Refresh lookups are limited to this many per second, on average, across passes.
+	// This is in addition to any RefreshSleepTime.
+	// 0 is unlimited.
+	ConfigRefreshRate = ConfigKey("RefreshRate")
+	// ConfigRefreshBurst is an int.
+	// This is the number of Refresh lookups that may be made at once, before RefreshRate applies.
+	// Defaults to 1.
+	ConfigRefreshBurst = ConfigKey("RefreshBurst")
+	// ConfigRefreshBackoff is a time.Duration.
+	// For values > 0, a failed Refresh lookup delays the next by this long, doubling with each consecutive
+	// failure up to RefreshBackoffMax, until a lookup succeeds. Not-found answers are not failures, here.
+	// 0 disables back-off.
+	ConfigRefreshBackoff = ConfigKey("RefreshBackoff")
+	// ConfigRefreshBackoffMax is a time.Duration.
+	// This is the longest the RefreshBackoff may grow to.
+	// Defaults to 30s.
+	ConfigRefreshBackoffMax = ConfigKey("RefreshBackoffMax")
+)
+
+// refreshLimiter paces the lookups of Refresh passes, according to a token bucket and any back-off.
+// The zero value is ready to use, and does not limit anything.
+type refreshLimiter struct {
+	rate       float64
+	burst      int
+	backoff    time.Duration
+	backoffMax time.Duration
+
+	lock     sync.Mutex
+	tokens   float64
+	last     time.Time
+	failures int
+}
+
+// config is an internal validator and applier for the ConfigRefreshRate, ConfigRefreshBurst,
+// and ConfigRefreshBackoff* ConfigOptions. The bool returned is false if the option is none of those.
+func (l *refreshLimiter) config(opt ConfigOption) (bool, error) {
+	switch opt.Key {
+	case ConfigRefreshRate:
+		if v, ok := opt.Value.(float64); ok && v >= 0 {
+			l.rate = v
+		} else if ok {
+			return true, fmt.Errorf("%s must be >= 0", opt.Key)
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigRefreshBurst:
+		if v, ok := opt.Value.(int); ok && v > 0 {
+			l.burst = v
+		} else if ok {
+			return true, fmt.Errorf("%s must be > 0", opt.Key)
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigRefreshBackoff:
+		if v, ok := opt.Value.(time.Duration); ok {
+			l.backoff = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigRefreshBackoffMax:
+		if v, ok := opt.Value.(time.Duration); ok {
+			l.backoffMax = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// resolver returns the ResolverFunc, waiting before each lookup as the rate and any back-off require.
+// If the Context is done while waiting, its error is returned instead of a lookup being made.
+func (l *refreshLimiter) resolver(ctx context.Context, logger *slog.Logger, f ResolverFunc) ResolverFunc {
+	if l.rate <= 0 && l.backoff <= 0 {
+		return f
+	}
+	return func(address string) ([]net.IP, error) {
+		if d := l.reserve(time.Now()); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		ips, err := f(address)
+		if d := l.result(err); d > 0 {
+			logger.Debug("refresh backing off", "address", address, "error", err, "delay", d)
+		}
+		return ips, err
+	}
+}
+
+// reserve takes a token from the bucket, and returns how long to wait before using it, including any back-off.
+// Tokens are reserved even if the wait is abandoned.
+func (l *refreshLimiter) reserve(now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	wait := l.delay()
+	if l.rate <= 0 {
+		return wait
+	}
+
+	burst := float64(max(l.burst, 1))
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = min(burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens < 0 {
+		wait += time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	return wait
+}
+
+// result records the outcome of a lookup, returning the back-off that now applies.
+func (l *refreshLimiter) result(err error) time.Duration {
+	var dnsErr *net.DNSError
+	if err != nil && errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		// an answer, not a failure of the resolver
+		err = nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err == nil {
+		l.failures = 0
+	} else if l.backoff > 0 {
+		l.failures++
+	}
+	return l.delay()
+}
+
+// delay returns the current back-off. The lock must be held.
+func (l *refreshLimiter) delay() time.Duration {
+	if l.failures == 0 || l.backoff <= 0 {
+		return 0
+	}
+	limit := l.backoffMax
+	if limit <= 0 {
+		limit = 30 * time.Second
+	}
+	d := l.backoff
+	for i := 1; i < l.failures && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// passContext returns a Context for a Refresh pass with the timeout, if non-zero.
+func passContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_RefreshLimiterReserve(t *testing.T) {
+	Convey("When a refreshLimiter has a rate, tokens are reserved from a bucket", t, func() {
+		var (
+			now = time.Now()
+			l   = refreshLimiter{rate: 10, burst: 2}
+		)
+		So(l.reserve(now), ShouldBeZeroValue)
+		So(l.reserve(now), ShouldBeZeroValue)
+		So(l.reserve(now), ShouldEqual, 100*time.Millisecond)
+		So(l.reserve(now), ShouldEqual, 200*time.Millisecond)
+
+		now = now.Add(time.Second) // refilled, but only to the burst
+		So(l.reserve(now), ShouldBeZeroValue)
+		So(l.reserve(now), ShouldBeZeroValue)
+		So(l.reserve(now), ShouldEqual, 100*time.Millisecond)
+
+		So((&refreshLimiter{}).reserve(now), ShouldBeZeroValue)
+	})
+
+	Convey("When a refreshLimiter has a back-off, it doubles with consecutive failures, up to the max", t, func() {
+		var (
+			l       = refreshLimiter{backoff: 10 * time.Millisecond, backoffMax: 35 * time.Millisecond}
+			failure = errors.New("server misbehaving")
+		)
+		So(l.result(failure), ShouldEqual, 10*time.Millisecond)
+		So(l.result(failure), ShouldEqual, 20*time.Millisecond)
+		So(l.result(failure), ShouldEqual, 35*time.Millisecond)
+		So(l.result(failure), ShouldEqual, 35*time.Millisecond)
+		So(l.reserve(time.Now()), ShouldEqual, 35*time.Millisecond)
+
+		So(l.result(&net.DNSError{Err: "no such host", IsNotFound: true}), ShouldBeZeroValue)
+		So(l.reserve(time.Now()), ShouldBeZeroValue)
+
+		l.result(failure)
+		So(l.result(nil), ShouldBeZeroValue)
+	})
+}
+
+func Test_RefreshRate(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a cache has a RefreshRate, Refresh lookups are paced by it", t, func() {
+		var lookups lookupLog
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRefreshRate, 50.0),
+			NewConfigOption(ConfigRefreshBurst, 2),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for i := range 5 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP("127.0.0.1")})
+		}
+
+		start := time.Now()
+		res, err := c.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Refreshed, ShouldEqual, 5)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 55*time.Millisecond) // 2 at once, then 3 at 20ms
+	})
+
+	Convey("When a rate-limited Refresh times out, it doesn't wait for the rate", t, func() {
+		var lookups lookupLog
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshType, RefreshBatch),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRefreshRate, 0.1),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for i := range 5 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP("127.0.0.1")})
+		}
+
+		start := time.Now()
+		res, err := c.RefreshResult(50 * time.Millisecond)
+		So(err, ShouldBeNil)
+		So(res.Refreshed, ShouldEqual, 1)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(lookups.drain(), ShouldHaveLength, 1)
+	})
+
+	Convey("When a cache has a RefreshBackoff, failed Refresh lookups delay the next", t, func() {
+		var lookups lookupLog
+		failing := func(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+			lookups.resolver()(ctx, address)
+			return nil, TTLUnknown, &net.DNSError{Err: "server misbehaving", Name: address, IsTemporary: true}
+		}
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(failing)),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRefreshBackoff, 10*time.Millisecond),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for i := range 3 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP("127.0.0.1")})
+		}
+
+		start := time.Now()
+		res, err := c.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Failures, ShouldHaveLength, 3)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 30*time.Millisecond) // 10ms, then 20ms
+	})
+
+	Convey("When the rate options are invalid, an error is returned", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigRefreshRate, -1.0))
+		So(err, ShouldBeError)
+		_, err = NewSimple(NewConfigOption(ConfigRefreshRate, 1))
+		So(err, ShouldEqual, ConfigRefreshRate.Error())
+		_, err = NewSimple(NewConfigOption(ConfigRefreshBurst, 0))
+		So(err, ShouldBeError)
+		_, err = NewLRU(NewConfigOption(ConfigSize, 10), NewConfigOption(ConfigRefreshBackoff, 10))
+		So(err, ShouldEqual, ConfigRefreshBackoff.Error())
+		_, err = NewLRU(NewConfigOption(ConfigSize, 10), NewConfigOption(ConfigRefreshBackoffMax, "1m"))
+		So(err, ShouldEqual, ConfigRefreshBackoffMax.Error())
+	})
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"log/slog"
+)
+
+const (
+	// ConfigLogger is a *slog.Logger.
+	// Refresh pass summaries, lookup failures, and config errors are logged to it.
+	// Defaults to discarding everything.
+	ConfigLogger = ConfigKey("Logger")
+)
+
+// discardLogger is the default Logger.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// loggerIn returns the Logger in the options, or discardLogger if there is none, or it is the wrong type.
+// Constructors use this to log config errors before the options are applied.
+func loggerIn(options []ConfigOption) *slog.Logger {
+	if v, ok := ConfigLogger.IsIn(options); ok {
+		if l, ok := v.(*slog.Logger); ok && l != nil {
+			return l
+		}
+	}
+	return discardLogger
+}
+
+// configError logs the error configuring a cache, and returns it.
+func configError(logger *slog.Logger, err error) error {
+	logger.Error("cache configuration failed", "error", err)
+	return err
+}
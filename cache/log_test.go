@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// syncBuffer is a goro-safe bytes.Buffer.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// bufferLogger returns a Logger that logs everything, as text, to the buffer.
+func bufferLogger(b *syncBuffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func Test_RefreshLogging(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Refresh pass has failures, they and a summary are logged", t, func() {
+		var (
+			fake = fakeResolver{ttl: TTLUnknown}
+			buf  syncBuffer
+		)
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRefreshShuffle, false),
+			NewConfigOption(ConfigLogger, bufferLogger(&buf)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		c.Fetch("one.one.one.one")
+		fake.fail.Store(true)
+		c.Refresh(0)
+
+		out := buf.String()
+		So(out, ShouldContainSubstring, `level=WARN msg="refresh lookup failed" address=dns.google.com error=boom`)
+		So(out, ShouldContainSubstring, `level=INFO msg="refresh pass finished" keys=2 refreshed=0 skipped=0 failures=2 timedout=false`)
+
+		Convey("and failed Fetches are logged at debug", func() {
+			c.Fetch("invalid.viki.io")
+			So(buf.String(), ShouldContainSubstring, `level=DEBUG msg="lookup failed" address=invalid.viki.io error=boom stale=false`)
+		})
+	})
+
+	Convey("When a Refresh pass skips evicted entries, they are counted", t, func() {
+		var buf syncBuffer
+
+		// c.localhost is "evicted" after the keys were gotten
+		tally := &refreshTally{
+			RefreshableCache: &evictingCache{keys: []string{"a.localhost", "b.localhost", "c.localhost"}, evicted: "c.localhost"},
+			logger:           bufferLogger(&buf),
+		}
+		completed, err := LinearRefresh(tally, tally.resolver(func(string) ([]net.IP, error) { return nil, nil }),
+			NewConfigOption(ConfigRefreshShuffle, false),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		logResult(tally.logger, tally.result(time.Second, completed))
+
+		So(buf.String(), ShouldContainSubstring, `level=DEBUG msg="refresh pass finished" keys=3 refreshed=2 skipped=1 failures=0 timedout=false took=1s`)
+	})
+}
+
+// evictingCache is a RefreshableCache whose keys all exist, except the evicted one.
+type evictingCache struct {
+	keys    []string
+	evicted string
+}
+
+func (e *evictingCache) Keys() []string               { return slices.Clone(e.keys) }
+func (e *evictingCache) Contains(address string) bool { return address != e.evicted }
+
+func Test_ConfigErrorLogging(t *testing.T) {
+	Convey("When a cache is misconfigured, the error is logged", t, func() {
+		var buf syncBuffer
+
+		_, err := NewSimple(
+			NewConfigOption(ConfigLogger, bufferLogger(&buf)),
+			NewConfigOption(ConfigRefreshSleepTime, "1s"),
+		)
+		So(err, ShouldBeError)
+		So(buf.String(), ShouldContainSubstring, `level=ERROR msg="cache configuration failed" error="value of option RefreshSleepTime is the wrong type"`)
+
+		_, err = NewLRU(NewConfigOption(ConfigLogger, bufferLogger(&buf)))
+		So(err, ShouldBeError)
+		So(buf.String(), ShouldContainSubstring, `error="option CacheSize is required"`)
+
+		_, err = NewSimple(NewConfigOption(ConfigLogger, "logger"))
+		So(err, ShouldEqual, ConfigLogger.Error())
+	})
+}
@@ -1,12 +1,18 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/hashicorp/golang-lru/v2/simplelru"
 )
 
 const (
@@ -22,103 +28,334 @@ const (
 // hashiLRU is an abstraction to let us reuse LRU, but support multiple LRU types via
 // different constructors.
 type hashiLRU interface {
-	Add(key string, value []net.IP)
+	Add(key string, value entry)
 	Contains(key string) bool
-	Get(key string) (value []net.IP, ok bool)
+	Get(key string) (value entry, ok bool)
+	Peek(key string) (value entry, ok bool)
 	Remove(key string)
 	Keys() []string
 	Len() int
 	Purge()
 }
 
+// evictFunc is called by the hashiLRU wrappers when the cache itself removes an entry,
+// due to size or age.
+type evictFunc func(key string, value entry)
+
 // I don't want to talk about it
 type expirableWrapper struct {
-	*expirable.LRU[string, []net.IP]
+	*expirable.LRU[string, entry]
+
+	evicted  evictFunc
+	removing sync.Map // keys being Removed, whose callbacks are not evictions
+	purging  atomic.Bool
+}
+
+// newExpirableWrapper returns an expirableWrapper around a new expirable.LRU.
+func newExpirableWrapper(size int, ttl time.Duration, evicted evictFunc) *expirableWrapper {
+	e := &expirableWrapper{evicted: evicted}
+	e.LRU = expirable.NewLRU(size, e.onEvict, ttl)
+	return e
+}
+
+// onEvict is the expirable.LRU callback, which is called for every removal, including ours.
+func (e *expirableWrapper) onEvict(key string, value entry) {
+	if e.purging.Load() {
+		return
+	}
+	if _, ok := e.removing.Load(key); ok {
+		return
+	}
+	e.evicted(key, value)
 }
 
-func (e *expirableWrapper) Add(key string, value []net.IP) {
+func (e *expirableWrapper) Add(key string, value entry) {
 	e.LRU.Add(key, value) // ignores the bool returned.
 }
 func (e *expirableWrapper) Remove(key string) {
+	e.removing.Store(key, struct{}{})
+	defer e.removing.Delete(key)
 	e.LRU.Remove(key) //ignores the bool returned.
 }
+func (e *expirableWrapper) Purge() {
+	e.purging.Store(true)
+	defer e.purging.Store(false)
+	e.LRU.Purge()
+}
+
+// twoQueue is the 2Q algorithm of lru.TwoQueueCache, with its default ratios, rebuilt on
+// simplelru so that the evictions it makes, silently in lru.TwoQueueCache, can be reported.
+type twoQueue struct {
+	size       int
+	recentSize int
+	evicted    evictFunc
+
+	lock        sync.Mutex
+	recent      *simplelru.LRU[string, entry]    // entries used once
+	frequent    *simplelru.LRU[string, entry]    // entries used more than once
+	recentEvict *simplelru.LRU[string, struct{}] // ghosts of the entries evicted from recent
+}
+
+// newTwoQueue returns an empty twoQueue of the size.
+func newTwoQueue(size int, evicted evictFunc) (*twoQueue, error) {
+	if size <= 0 {
+		return nil, errors.New("invalid size")
+	}
+	recent, _ := simplelru.NewLRU[string, entry](size, nil)   // size checked
+	frequent, _ := simplelru.NewLRU[string, entry](size, nil) // size checked
+	recentEvict, err := simplelru.NewLRU[string, struct{}](int(float64(size)*lru.Default2QGhostEntries), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &twoQueue{
+		size:        size,
+		recentSize:  int(float64(size) * lru.Default2QRecentRatio),
+		evicted:     evicted,
+		recent:      recent,
+		frequent:    frequent,
+		recentEvict: recentEvict,
+	}, nil
+}
+
+// Add adds the value, reporting the entry evicted to make room for it, if any.
+func (t *twoQueue) Add(key string, value entry) {
+	t.lock.Lock()
+
+	var (
+		evictedKey   string
+		evictedValue entry
+		evicted      bool
+	)
+	switch {
+	case t.frequent.Contains(key):
+		t.frequent.Add(key, value)
+	case t.recent.Contains(key):
+		t.recent.Remove(key)
+		t.frequent.Add(key, value)
+	case t.recentEvict.Contains(key):
+		evictedKey, evictedValue, evicted = t.ensureSpace(true)
+		t.recentEvict.Remove(key)
+		t.frequent.Add(key, value)
+	default:
+		evictedKey, evictedValue, evicted = t.ensureSpace(false)
+		t.recent.Add(key, value)
+	}
+	t.lock.Unlock()
+
+	// reported unlocked, in case the evictFunc is slow
+	if evicted {
+		t.evicted(evictedKey, evictedValue)
+	}
+}
+
+// ensureSpace evicts an entry if the cache is full, and returns it, and true, or false if not.
+// recentEvict is true if the entry being added is a ghost of recent.
+// The lock must be held.
+func (t *twoQueue) ensureSpace(recentEvict bool) (string, entry, bool) {
+	recentLen := t.recent.Len()
+	if recentLen+t.frequent.Len() < t.size {
+		return "", entry{}, false
+	}
+
+	if recentLen > 0 && (recentLen > t.recentSize || (recentLen == t.recentSize && !recentEvict)) {
+		k, v, ok := t.recent.RemoveOldest()
+		t.recentEvict.Add(k, struct{}{})
+		return k, v, ok
+	}
+	return t.frequent.RemoveOldest()
+}
+
+func (t *twoQueue) Contains(key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.frequent.Contains(key) || t.recent.Contains(key)
+}
+
+// Get returns the value of the key, promoting it to frequent.
+func (t *twoQueue) Get(key string) (entry, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if v, ok := t.frequent.Get(key); ok {
+		return v, true
+	}
+	if v, ok := t.recent.Peek(key); ok {
+		t.recent.Remove(key)
+		t.frequent.Add(key, v)
+		return v, true
+	}
+	return entry{}, false
+}
+
+func (t *twoQueue) Peek(key string) (entry, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if v, ok := t.frequent.Peek(key); ok {
+		return v, true
+	}
+	return t.recent.Peek(key)
+}
+
+func (t *twoQueue) Remove(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.frequent.Remove(key) && !t.recent.Remove(key) {
+		t.recentEvict.Remove(key)
+	}
+}
+
+// Keys returns the keys, those of frequent first, oldest first.
+func (t *twoQueue) Keys() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append(t.frequent.Keys(), t.recent.Keys()...)
+}
+
+func (t *twoQueue) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.recent.Len() + t.frequent.Len()
+}
+
+func (t *twoQueue) Purge() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.recent.Purge()
+	t.frequent.Purge()
+	t.recentEvict.Purge()
+}
 
 // LRU is a "least recently used" cache of fixed size, that evicts items
 // when necessary to free space for more. If ItemTTL is specified, then
 // the cache will automatically evict items that are unaccessed beyond that point.
 type LRU struct {
-	cache hashiLRU
+	cache   hashiLRU
+	flights flightGroup[entry]
 
-	resolver         ResolverFunc
+	resolver         ResolverTTLFunc
+	values           valueResolver // if the store of a Cache, instead of resolver
 	refreshShuffle   bool
 	refreshSleepTime time.Duration
 	refreshType      RefreshType
 	refresh          RefreshFunc
 	refreshBatchSize int
+	ttl              ttlConfig
+	negatives        negativeCache
+	popularity       popularityConfig
+	prefetch         prefetcher
+	limiter          refreshLimiter
+	serveStale       time.Duration
+	stats            counters
+	observer         observerList
+	logger           *slog.Logger
+	resolverName     string
+	itemTTL          time.Duration
+	closer           *closer
 }
 
 // NewLRU instantiates an LRU cache.
 // If ItemTTL is specified, an expirable cache is created, otherwise a twoqueue cache is used.
-// Valid ConfigOptions are: Resolver, RefreshShuffle, RefreshSleepTime, AllowRefresh, ItemTTL, Size.
+// Valid ConfigOptions are: Resolver, RefreshShuffle, RefreshSleepTime, AllowRefresh, ItemTTL, Size,
+// RecordTTL, RecordTTLMin, RecordTTLMax, NegativeTTL, NegativeTemporaryTTL, ServeStale, Recorder, Observer, Logger,
+// ResolverName, RefreshMinHits, RefreshIdlePasses, Prefetch, RefreshRate, RefreshBurst, RefreshBackoff, RefreshBackoffMax.
 // Required are: Size.
-// Defaults are: Resolver(DefaultResolver), RefreshShuffle(true), RefreshSleepTime(1s), AllowRefresh(true).
+// Defaults are: Resolver(DefaultResolverContext, or DefaultResolver if replaced), RefreshShuffle(true), RefreshSleepTime(1s), AllowRefresh(true),
+// RecordTTL(false), NegativeTTL(0), ServeStale(0), Logger(discard), ResolverName("Resolver"), RefreshMinHits(1),
+// RefreshIdlePasses(0), Prefetch(0), RefreshRate(0), RefreshBurst(1), RefreshBackoff(0), RefreshBackoffMax(30s).
+// If ItemTTL and ServeStale are both specified, items are retained for ItemTTL+ServeStale, but are
+// only served fresh for ItemTTL.
 func NewLRU(options ...ConfigOption) (*LRU, error) {
+	logger := loggerIn(options)
+
 	var cacheSize int
 	if v, ok := ConfigSize.IsIn(options); !ok {
-		return nil, fmt.Errorf("option %s is required", ConfigSize)
+		return nil, configError(logger, fmt.Errorf("option %s is required", ConfigSize))
 	} else if cacheSize, ok = v.(int); !ok {
-		return nil, ConfigSize.Error()
+		return nil, configError(logger, ConfigSize.Error())
 	}
 
 	var (
 		cache hashiLRU
 		err   error
 		ttl   time.Duration
+		stale time.Duration
 	)
 
-	// Requirements
-	if v, ok := ConfigItemTTL.IsIn(options); ok {
-		// We want an expirable cache
-		if ttl, ok = v.(time.Duration); !ok {
-			return nil, ConfigItemTTL.Error()
+	if v, ok := ConfigServeStale.IsIn(options); ok {
+		if stale, ok = v.(time.Duration); !ok {
+			return nil, configError(logger, ConfigServeStale.Error())
 		}
-		cache = &expirableWrapper{expirable.NewLRU[string, []net.IP](cacheSize, nil, ttl)}
-	} else {
-		// We do not want an expirable cache
-		cache, err = lru.New2Q[string, []net.IP](cacheSize)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("error instantiating lru: %w", err)
 	}
 
 	// Set defaults
 	l := LRU{
-		cache:            cache,
 		refreshShuffle:   true,
 		refreshSleepTime: 1 * time.Second,
-		resolver:         DefaultResolver,
+		resolver:         defaultResolver(),
 		refresh:          LinearRefresh,
 		refreshType:      RefreshLinear,
 		refreshBatchSize: 15,
+		logger:           logger,
+		resolverName:     "Resolver",
+		closer:           newCloser(),
 	}
 
+	// Requirements
+	if v, ok := ConfigItemTTL.IsIn(options); ok {
+		// We want an expirable cache
+		if ttl, ok = v.(time.Duration); !ok {
+			return nil, configError(logger, ConfigItemTTL.Error())
+		}
+		// stale items need to live past their TTL
+		cache = newExpirableWrapper(cacheSize, ttl+max(stale, 0), l.evicted)
+	} else {
+		// We do not want an expirable cache
+		cache, err = newTwoQueue(cacheSize, l.evicted)
+	}
+	if err != nil {
+		return nil, configError(logger, fmt.Errorf("error instantiating lru: %w", err))
+	}
+	l.cache = cache
+	l.itemTTL = ttl
+
 	// Apply options
 	var e error
 	for _, o := range options {
 		e = l.config(o)
 		if e != nil {
-			return nil, e
+			return nil, configError(l.logger, e)
 		}
 	}
+	if e = validateRefresh(l.refresh, l.refreshOptions(context.Background(), 0)); e != nil {
+		return nil, configError(l.logger, e)
+	}
 
 	return &l, nil
 }
 
 // config is an internal validator and applier for ConfigOptions
 func (r *LRU) config(opt ConfigOption) error {
+	if ok, err := r.ttl.config(opt); ok {
+		return err
+	}
+	if ok, err := r.negatives.config(opt); ok {
+		return err
+	}
+	if ok, err := r.stats.config(opt); ok {
+		return err
+	}
+	if ok, err := r.popularity.config(opt); ok {
+		return err
+	}
+	if ok, err := r.prefetch.config(opt); ok {
+		return err
+	}
+	if ok, err := r.limiter.config(opt); ok {
+		return err
+	}
+
 	switch opt.Key {
 	case ConfigResolver:
-		if v, ok := opt.Value.(ResolverFunc); ok {
+		if v, ok := toResolverTTLFunc(opt.Value); ok {
 			r.resolver = v
 		} else {
 			return opt.Key.Error()
@@ -137,22 +374,20 @@ func (r *LRU) config(opt ConfigOption) error {
 		}
 	case ConfigRefreshType:
 		if v, ok := opt.Value.(RefreshType); ok {
-			r.refreshType = v
-			switch v {
-			case RefreshOff:
-				r.refresh = NoRefresh
-			case RefreshLinear:
-				r.refresh = LinearRefresh
-			case RefreshBatch:
-				r.refresh = BatchRefresh
-
+			f, ok := refreshFuncFor(v)
+			if !ok {
+				return fmt.Errorf("unknown %s %q", opt.Key, v)
 			}
+			r.refreshType = v
+			r.refresh = f
 		} else {
 			return opt.Key.Error()
 		}
 	case ConfigRefreshBatchSize:
-		if v, ok := opt.Value.(int); ok {
+		if v, ok := opt.Value.(int); ok && v > 0 {
 			r.refreshBatchSize = v
+		} else if ok {
+			return fmt.Errorf("%s must be > 0", opt.Key)
 		} else {
 			return opt.Key.Error()
 		}
@@ -166,6 +401,30 @@ func (r *LRU) config(opt ConfigOption) error {
 		if _, ok := opt.Value.(int); !ok {
 			return opt.Key.Error()
 		}
+	case ConfigServeStale:
+		if v, ok := opt.Value.(time.Duration); ok {
+			r.serveStale = v
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigObserver:
+		if v, ok := opt.Value.(Observer); ok && v != nil {
+			r.observer.add(v)
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigLogger:
+		if v, ok := opt.Value.(*slog.Logger); ok && v != nil {
+			r.logger = v
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigResolverName:
+		if v, ok := opt.Value.(string); ok {
+			r.resolverName = v
+		} else {
+			return opt.Key.Error()
+		}
 	default:
 		return ErrorConfigKeyUnsupported
 	}
@@ -175,77 +434,271 @@ func (r *LRU) config(opt ConfigOption) error {
 // Fetch retrieves a collection from the cache,
 // or performs a live lookup and adds it to the cache.
 func (r *LRU) Fetch(address string) ([]net.IP, error) {
-	ips, exists := r.cache.Get(address)
-	if exists {
-		return ips, nil
+	return r.FetchContext(context.Background(), address)
+}
+
+// FetchContext retrieves a collection from the cache,
+// or performs a live lookup, honoring the Context, and adds it to the cache.
+// If negative caching is enabled, and a previous lookup failed, its *NegativeError is returned until it expires.
+func (r *LRU) FetchContext(ctx context.Context, address string) ([]net.IP, error) {
+	ips, _, err := r.FetchStale(ctx, address)
+	return ips, err
+}
+
+// FetchStale is FetchContext, but also returns true if the collection returned
+// is a stale entry, served because the live lookup failed.
+// Stale entries are only served if ServeStale is enabled.
+func (r *LRU) FetchStale(ctx context.Context, address string) ([]net.IP, bool, error) {
+	e, stale, err := r.fetch(ctx, address)
+	return e.ips, stale, err
+}
+
+// fetch is FetchStale, but returns the entry.
+func (r *LRU) fetch(ctx context.Context, address string) (entry, bool, error) {
+	now := time.Now()
+
+	e, exists := r.cache.Get(address)
+	if exists && !e.expired(now) {
+		e.hit()
+		r.stats.hits.Add(1)
+		r.observer.Hit(address, false)
+		if r.prefetch.due(&e, now) {
+			r.startPrefetch(address)
+		}
+		return e, false, nil
 	}
+	stale := exists && e.servable(r.serveStale, now)
 
-	return r.Lookup(address)
+	if ne, ok := r.negatives.get(address); ok {
+		if stale {
+			e.hit()
+			r.stats.staleHits.Add(1)
+			r.observer.Hit(address, true)
+			return e, true, nil
+		}
+		r.stats.negativeHits.Add(1)
+		return entry{}, false, ne
+	}
+
+	r.stats.misses.Add(1)
+	r.observer.Miss(address)
+	le, err := r.lookupEntry(ctx, address)
+	if err != nil {
+		r.logger.Debug("lookup failed", "address", address, "error", err, "stale", stale)
+	}
+	if err != nil && stale {
+		e.hit()
+		r.stats.staleHits.Add(1)
+		r.observer.Hit(address, true)
+		return e, true, nil
+	}
+	return le, false, err
+}
+
+// startPrefetch starts a background lookup of the address, unless one is already in flight.
+func (r *LRU) startPrefetch(address string) {
+	started := r.prefetch.start(address, func(ctx context.Context, address string) {
+		if _, err := r.LookupContext(ctx, address); err != nil {
+			r.logger.Debug("prefetch failed", "address", address, "error", err)
+		}
+	})
+	if started {
+		r.stats.prefetches.Add(1)
+	}
 }
 
 // Lookup performs a live lookup,
 // and adds the results to the cache.
 func (r *LRU) Lookup(address string) ([]net.IP, error) {
-	ips, err := r.resolver(address)
+	return r.LookupContext(context.Background(), address)
+}
+
+// LookupContext performs a live lookup, honoring the Context,
+// and adds the results to the cache.
+// Concurrent lookups of the same address are coalesced into a single resolver call.
+func (r *LRU) LookupContext(ctx context.Context, address string) ([]net.IP, error) {
+	e, err := r.lookupEntry(ctx, address)
+	return e.ips, err
+}
+
+// lookupEntry is LookupContext, but returns the entry.
+func (r *LRU) lookupEntry(ctx context.Context, address string) (entry, error) {
+	return r.flights.do(ctx, address, func(ctx context.Context) (entry, error) {
+		return r.lookup(ctx, address)
+	})
+}
+
+// lookup is the uncoalesced resolver call and cache update.
+func (r *LRU) lookup(ctx context.Context, address string) (entry, error) {
+	var (
+		ips   []net.IP
+		value any
+		ttl   time.Duration
+		err   error
+	)
+	r.observer.LookupStart(address)
+	start := time.Now()
+	if r.values != nil {
+		value, ttl, err = r.values(ctx, address)
+	} else {
+		ips, ttl, err = r.resolver(ctx, address)
+	}
+	took := time.Since(start)
+	r.stats.lookup(took, err)
+	r.observer.LookupFinish(address, ips, err, took)
 	if err != nil {
-		return nil, err
+		r.negatives.add(address, err)
+		if e, ok := r.cache.Peek(address); ok {
+			e.failed(err)
+		}
+		return entry{}, err
 	}
+	r.negatives.remove(address)
+
+	e := r.newEntry(ips, ttl, time.Now(), r.resolverName)
+	e.value = value
+	r.store(address, e)
+	return e, nil
+}
+
+// store upserts the entry, carrying over the metadata of any it replaces, and tells the Observer.
+func (r *LRU) store(address string, e entry) {
+	old, existed := r.cache.Peek(address)
+	e.carry(old, existed)
+	r.cache.Add(address, e)
 
-	r.cache.Add(address, ips)
-	return ips, nil
+	observeStored(&r.observer, address, old.ips, existed, e.ips)
 }
 
 // Purge removes all entries from the cache.
 func (r *LRU) Purge() {
+	r.negatives.purge()
 	r.cache.Purge()
 }
 
 // Refresh will crawl the keys and update the cache with new values.
+// If RecordTTL is enabled, only expired entries, and those without a TTL, are refreshed.
+// Errors are logged, rather than returned. See RefreshResult.
 func (r *LRU) Refresh(timeout time.Duration) {
-	var err error
-
-	if r.refreshType != RefreshBatch {
-		_, err = r.refresh(r, r.Lookup,
-			NewConfigOption(ConfigRefreshShuffle, r.refreshShuffle),
-			NewConfigOption(ConfigRefreshSleepTime, r.refreshSleepTime),
-			NewConfigOption(ConfigRefreshTimeout, timeout),
-		)
-	} else {
-		// batch
-		_, err = r.refresh(r, r.Lookup,
-			NewConfigOption(ConfigRefreshShuffle, r.refreshShuffle),
-			NewConfigOption(ConfigRefreshSleepTime, r.refreshSleepTime),
-			NewConfigOption(ConfigRefreshTimeout, timeout),
-			NewConfigOption(ConfigRefreshBatchSize, r.refreshBatchSize),
-		)
+	r.RefreshResult(timeout)
+}
+
+// RefreshResult is Refresh, but returns the RefreshResult of the pass, or an error
+// if the RefreshFunc failed, or was cancelled by Close. ErrorClosed is returned if the cache is closed.
+func (r *LRU) RefreshResult(timeout time.Duration) (RefreshResult, error) {
+	if !r.closer.enter() {
+		return RefreshResult{}, ErrorClosed
+	}
+	defer r.closer.exit()
+
+	var cache RefreshableCache = r
+	start := time.Now()
+	r.observer.RefreshStart()
+
+	if r.serveStale > 0 {
+		r.pruneStale(start)
+	}
+	if r.ttl.enabled {
+		cache = &dueCache{RefreshableCache: r, due: r.due}
 	}
+	tally := &refreshTally{RefreshableCache: cache, logger: r.logger}
+	ctx, cancel := passContext(r.closer.ctx, timeout)
+	defer cancel()
 
+	resolver := r.limiter.resolver(ctx, r.logger, tally.resolver(r.refreshLookup))
+	completed, err := r.refresh(tally, resolver, r.refreshOptions(r.closer.ctx, timeout)...)
+	if completed && err == nil {
+		r.endPass()
+	}
+	res := tally.result(time.Since(start), completed && err == nil)
+	r.observer.RefreshEnd(res.Took, res.Completed)
 	if err != nil {
-		panic(fmt.Errorf("error during RefreshFunc: %w", err))
+		return res, refreshError(r.logger, err)
+	}
+
+	r.stats.refresh(res.Took, res.Completed)
+	logResult(r.logger, res)
+	return res, nil
+}
+
+// refreshLookup is Lookup, for Refresh passes. Close cancels it, and waits for the resolver call it
+// started, if any. Calls it joined are another caller's to wait for.
+func (r *LRU) refreshLookup(address string) ([]net.IP, error) {
+	e, err := r.flights.do(r.closer.ctx, address, func(ctx context.Context) (entry, error) {
+		if !r.closer.enter() {
+			return entry{}, ErrorClosed
+		}
+		defer r.closer.exit()
+		return r.lookup(ctx, address)
+	})
+	return e.ips, err
+}
+
+// refreshOptions returns the ConfigOptions for the RefreshFunc.
+func (r *LRU) refreshOptions(ctx context.Context, timeout time.Duration) []ConfigOption {
+	options := []ConfigOption{
+		NewConfigOption(ConfigRefreshShuffle, r.refreshShuffle),
+		NewConfigOption(ConfigRefreshSleepTime, r.refreshSleepTime),
+		NewConfigOption(ConfigRefreshTimeout, timeout),
+		NewConfigOption(ConfigRefreshContext, ctx),
+	}
+	if r.refreshType == RefreshBatch {
+		options = append(options, NewConfigOption(ConfigRefreshBatchSize, r.refreshBatchSize))
+	}
+	if r.refreshType == RefreshPopular {
+		options = append(options, r.popularity.option())
 	}
+	return options
 }
 
-// Close is a noop. Satisfies ResolverCache
+// Close cancels any Refresh, and prefetches, in progress, and waits for them to finish.
+// Refreshes are refused thereafter. Satisfies ResolverCache
 func (r *LRU) Close() error {
+	r.closer.close()
+	r.prefetch.close()
 	return nil
 }
 
 // Add will upsert a collection into the cache.
 func (r *LRU) Add(key string, value []net.IP) {
-	r.cache.Add(key, value)
+	r.store(key, r.newEntry(value, TTLUnknown, time.Now(), SourceAdd))
 }
 
-// Remove will remove a collection from the cache, if it exists.
+// addValue will upsert the value of a Cache into the cache.
+func (r *LRU) addValue(key string, value any) {
+	e := r.newEntry(nil, TTLUnknown, time.Now(), SourceAdd)
+	e.value = value
+	r.store(key, e)
+}
+
+// Remove will remove a collection, or negatively-cached failure, from the cache, if it exists.
 func (r *LRU) Remove(key string) {
+	r.negatives.remove(key)
 	r.cache.Remove(key)
 }
 
 // Get will return a collection from the cache, also bool if
 // a collection was retrieved.
 func (r *LRU) Get(key string) ([]net.IP, bool) {
+	e, ok := r.get(key)
+	return e.ips, ok
+}
+
+// get returns the entry for the key, and true, or false if there is none.
+func (r *LRU) get(key string) (entry, bool) {
 	return r.cache.Get(key)
 }
 
+// GetEntry returns the Entry for the address, and true, or false if there is none.
+// The recency and hit count of the entry are not updated.
+func (r *LRU) GetEntry(address string) (Entry, bool) {
+	e, ok := r.cache.Peek(address)
+	if !ok {
+		return Entry{}, false
+	}
+	return e.export(), true
+}
+
 // Len will return the number of items in the cache.
 func (r *LRU) Len() int {
 	return r.cache.Len()
@@ -260,3 +713,78 @@ func (r *LRU) Contains(address string) bool {
 func (r *LRU) Keys() []string {
 	return r.cache.Keys()
 }
+
+// Expiring returns true if entries expire according to their record TTLs, and are
+// refreshed as they do.
+func (r *LRU) Expiring() bool {
+	return r.ttl.enabled
+}
+
+// NextExpiry returns the earliest expiry of any entry that is after the specified Time,
+// or the zero Time if there is none.
+func (r *LRU) NextExpiry(after time.Time) time.Time {
+	var next time.Time
+	for _, k := range r.cache.Keys() {
+		if e, ok := r.cache.Peek(k); ok && e.expires.After(after) && (next.IsZero() || e.expires.Before(next)) {
+			next = e.expires
+		}
+	}
+	return next
+}
+
+// due returns true if the address is in the cache, and due a Refresh.
+// The recency of the entry is not updated.
+func (r *LRU) due(address string, now time.Time) bool {
+	e, ok := r.cache.Peek(address)
+	return ok && e.due(now)
+}
+
+// newEntry returns an entry for the collection from the source, expiring according to the record TTL and
+// the ItemTTL.
+func (r *LRU) newEntry(ips []net.IP, ttl time.Duration, now time.Time, source string) entry {
+	e := entry{ips: ips, expires: r.ttl.expiry(ttl, now), refreshed: now, source: source}
+	if r.itemTTL > 0 {
+		if ie := now.Add(r.itemTTL); e.expires.IsZero() || ie.Before(e.expires) {
+			e.expires = ie
+		}
+	}
+	return e
+}
+
+// pruneStale removes the entries that are beyond the stale window at now.
+func (r *LRU) pruneStale(now time.Time) {
+	for _, k := range r.cache.Keys() {
+		if e, ok := r.cache.Peek(k); ok && e.defunct(r.serveStale, now) {
+			r.cache.Remove(k)
+			r.evicted(k, e)
+		}
+	}
+}
+
+// evicted is called when the cache itself removes an entry.
+func (r *LRU) evicted(key string, value entry) {
+	r.stats.evictions.Add(1)
+	r.observer.EntryEvicted(key, value.ips)
+}
+
+// endPass ends a completed Refresh pass for the entries, evicting any that have been idle for RefreshIdlePasses.
+// The recency of the entries is not updated.
+func (r *LRU) endPass() {
+	for _, k := range r.cache.Keys() {
+		if e, ok := r.cache.Peek(k); ok && e.endPass(r.popularity.idlePasses) {
+			r.cache.Remove(k)
+			r.evicted(k, e)
+		}
+	}
+}
+
+// Stats returns a snapshot of the statistics of the cache.
+func (r *LRU) Stats() Stats {
+	return r.stats.snapshot()
+}
+
+// Observe adds the Observer, in addition to any configured via ConfigObserver,
+// returning a func to remove it.
+func (r *LRU) Observe(o Observer) func() {
+	return r.observer.add(o)
+}
@@ -1,11 +1,14 @@
 package cache
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
+	lru "github.com/hashicorp/golang-lru/v2"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -246,3 +249,71 @@ func Test_LRUEmptyCacheRefresh(t *testing.T) {
 		So(after, ShouldHappenWithin, 10*time.Millisecond, start)
 	})
 }
+
+func Test_LRULookupContext(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When an LRU is created with a ResolverContextFunc, the Context reaches the resolver", t, func() {
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, ResolverContextFunc(blockingResolver)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		ips, err := c.FetchContext(ctx, "dns.google.com")
+		So(ips, ShouldBeNil)
+		So(err, ShouldEqual, context.Canceled)
+		So(c.Len(), ShouldEqual, 0)
+	})
+}
+
+func Test_TwoQueueEvictions(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a twoQueue is used, it keeps what lru.TwoQueueCache keeps, and reports what it evicts", t, func() {
+		var evicted []string
+		q, err := newTwoQueue(8, func(key string, _ entry) { evicted = append(evicted, key) })
+		So(err, ShouldBeNil)
+		reference, err := lru.New2Q[string, entry](8)
+		So(err, ShouldBeNil)
+
+		// adds, re-adds of ghosts, and Gets promoting entries to frequent
+		for i := range 200 {
+			key := fmt.Sprintf("%d.localhost", (i*7)%23)
+			if i%3 == 0 {
+				q.Get(key)
+				reference.Get(key)
+				continue
+			}
+			before := q.Len()
+			had := q.Contains(key)
+			evicted = evicted[:0]
+
+			q.Add(key, entry{})
+			reference.Add(key, entry{})
+
+			So(q.Keys(), ShouldResemble, reference.Keys())
+			if !had && before == 8 {
+				So(evicted, ShouldHaveLength, 1)
+				So(reference.Contains(evicted[0]), ShouldBeFalse)
+			} else {
+				So(evicted, ShouldBeEmpty)
+			}
+		}
+
+		evicted = evicted[:0]
+		q.Remove(q.Keys()[0])
+		q.Purge()
+		So(q.Len(), ShouldBeZeroValue)
+		So(evicted, ShouldHaveLength, 0)
+	})
+
+	Convey("When a twoQueue has no size, an error is returned", t, func() {
+		_, err := newTwoQueue(0, nil)
+		So(err, ShouldBeError)
+	})
+}
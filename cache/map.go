@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"maps"
 	"net"
 	"slices"
@@ -10,7 +12,8 @@ import (
 )
 
 const (
-	// ConfigResolver is a ResolverFunc.
+	// ConfigResolver is a ResolverFunc, a ResolverContextFunc, or a ResolverTTLFunc.
+	// A ResolverFunc will not see the Context passed to FetchContext or LookupContext.
 	ConfigResolver = ConfigKey("Resolver")
 	// ConfigRefreshShuffle is a bool.
 	// True will "shuffle" cache items before the Refresh begins.
@@ -23,32 +26,50 @@ const (
 
 // Simple is a mutex-controlled map-based ResolverCache.
 type Simple struct {
-	lock  sync.RWMutex
-	cache map[string][]net.IP
-	done  chan struct{}
+	lock    sync.RWMutex
+	cache   map[string]entry
+	closer  *closer
+	flights flightGroup[entry]
 
-	resolver         ResolverFunc
+	resolver         ResolverTTLFunc
+	values           valueResolver // if the store of a Cache, instead of resolver
 	refreshShuffle   bool
 	refreshSleepTime time.Duration
 	refreshType      RefreshType
 	refresh          RefreshFunc
 	refreshBatchSize int
+	ttl              ttlConfig
+	negatives        negativeCache
+	popularity       popularityConfig
+	prefetch         prefetcher
+	limiter          refreshLimiter
+	serveStale       time.Duration
+	stats            counters
+	observer         observerList
+	logger           *slog.Logger
+	resolverName     string
 }
 
 // NewSimple instantiates a Simple cache.
-// Valid ConfigOptions are: Resolver, RefreshShuffle, RefreshSleepTime.
+// Valid ConfigOptions are: Resolver, RefreshShuffle, RefreshSleepTime, RecordTTL, RecordTTLMin, RecordTTLMax,
+// NegativeTTL, NegativeTemporaryTTL, ServeStale, Recorder, Observer, Logger, ResolverName, RefreshMinHits,
+// RefreshIdlePasses, Prefetch, RefreshRate, RefreshBurst, RefreshBackoff, RefreshBackoffMax.
 // Required are: none.
-// Defaults are: Resolver(DefaultResolver), RefreshShuffle(true), RefreshSleepTime(1s)
+// Defaults are: Resolver(DefaultResolverContext, or DefaultResolver if replaced), RefreshShuffle(true), RefreshSleepTime(1s), RecordTTL(false),
+// NegativeTTL(0), ServeStale(0), Logger(discard), ResolverName("Resolver"), RefreshMinHits(1), RefreshIdlePasses(0),
+// Prefetch(0), RefreshRate(0), RefreshBurst(1), RefreshBackoff(0), RefreshBackoffMax(30s)
 func NewSimple(options ...ConfigOption) (*Simple, error) {
 	s := Simple{
-		cache:            make(map[string][]net.IP, 64),
-		done:             make(chan struct{}),
+		cache:            make(map[string]entry, 64),
+		closer:           newCloser(),
 		refreshShuffle:   true,
 		refreshSleepTime: 1 * time.Second,
-		resolver:         DefaultResolver,
+		resolver:         defaultResolver(),
 		refresh:          LinearRefresh,
 		refreshType:      RefreshLinear,
 		refreshBatchSize: 15,
+		logger:           loggerIn(options),
+		resolverName:     "Resolver",
 	}
 
 	// Apply options
@@ -56,18 +77,40 @@ func NewSimple(options ...ConfigOption) (*Simple, error) {
 	for _, o := range options {
 		e = s.config(o)
 		if e != nil {
-			return nil, e
+			return nil, configError(s.logger, e)
 		}
 	}
+	if e = validateRefresh(s.refresh, s.refreshOptions(context.Background(), 0)); e != nil {
+		return nil, configError(s.logger, e)
+	}
 
 	return &s, nil
 }
 
 // config is an internal validator and applier for ConfigOptions
 func (r *Simple) config(opt ConfigOption) error {
+	if ok, err := r.ttl.config(opt); ok {
+		return err
+	}
+	if ok, err := r.negatives.config(opt); ok {
+		return err
+	}
+	if ok, err := r.stats.config(opt); ok {
+		return err
+	}
+	if ok, err := r.popularity.config(opt); ok {
+		return err
+	}
+	if ok, err := r.prefetch.config(opt); ok {
+		return err
+	}
+	if ok, err := r.limiter.config(opt); ok {
+		return err
+	}
+
 	switch opt.Key {
 	case ConfigResolver:
-		if v, ok := opt.Value.(ResolverFunc); ok {
+		if v, ok := toResolverTTLFunc(opt.Value); ok {
 			r.resolver = v
 		} else {
 			return opt.Key.Error()
@@ -86,22 +129,44 @@ func (r *Simple) config(opt ConfigOption) error {
 		}
 	case ConfigRefreshType:
 		if v, ok := opt.Value.(RefreshType); ok {
-			r.refreshType = v
-			switch v {
-			case RefreshOff:
-				r.refresh = NoRefresh
-			case RefreshLinear:
-				r.refresh = LinearRefresh
-			case RefreshBatch:
-				r.refresh = BatchRefresh
-
+			f, ok := refreshFuncFor(v)
+			if !ok {
+				return fmt.Errorf("unknown %s %q", opt.Key, v)
 			}
+			r.refreshType = v
+			r.refresh = f
 		} else {
 			return opt.Key.Error()
 		}
 	case ConfigRefreshBatchSize:
-		if v, ok := opt.Value.(int); ok {
+		if v, ok := opt.Value.(int); ok && v > 0 {
 			r.refreshBatchSize = v
+		} else if ok {
+			return fmt.Errorf("%s must be > 0", opt.Key)
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigServeStale:
+		if v, ok := opt.Value.(time.Duration); ok {
+			r.serveStale = v
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigObserver:
+		if v, ok := opt.Value.(Observer); ok && v != nil {
+			r.observer.add(v)
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigLogger:
+		if v, ok := opt.Value.(*slog.Logger); ok && v != nil {
+			r.logger = v
+		} else {
+			return opt.Key.Error()
+		}
+	case ConfigResolverName:
+		if v, ok := opt.Value.(string); ok {
+			r.resolverName = v
 		} else {
 			return opt.Key.Error()
 		}
@@ -114,80 +179,257 @@ func (r *Simple) config(opt ConfigOption) error {
 // Fetch retrieves a collection from the cache,
 // or performs a live lookup and adds it to the cache.
 func (r *Simple) Fetch(address string) ([]net.IP, error) {
+	return r.FetchContext(context.Background(), address)
+}
+
+// FetchContext retrieves a collection from the cache,
+// or performs a live lookup, honoring the Context, and adds it to the cache.
+// If negative caching is enabled, and a previous lookup failed, its *NegativeError is returned until it expires.
+func (r *Simple) FetchContext(ctx context.Context, address string) ([]net.IP, error) {
+	ips, _, err := r.FetchStale(ctx, address)
+	return ips, err
+}
+
+// FetchStale is FetchContext, but also returns true if the collection returned
+// is a stale entry, served because the live lookup failed.
+// Stale entries are only served if ServeStale is enabled.
+func (r *Simple) FetchStale(ctx context.Context, address string) ([]net.IP, bool, error) {
+	e, stale, err := r.fetch(ctx, address)
+	return e.ips, stale, err
+}
+
+// fetch is FetchStale, but returns the entry.
+func (r *Simple) fetch(ctx context.Context, address string) (entry, bool, error) {
+	now := time.Now()
+
 	r.lock.RLock()
-	ips, exists := r.cache[address]
+	e, exists := r.cache[address]
 	r.lock.RUnlock()
-	if exists {
-		return ips, nil
+	if exists && !e.expired(now) {
+		e.hit()
+		r.stats.hits.Add(1)
+		r.observer.Hit(address, false)
+		if r.prefetch.due(&e, now) {
+			r.startPrefetch(address)
+		}
+		return e, false, nil
+	}
+	stale := exists && e.servable(r.serveStale, now)
+
+	if ne, ok := r.negatives.get(address); ok {
+		if stale {
+			e.hit()
+			r.stats.staleHits.Add(1)
+			r.observer.Hit(address, true)
+			return e, true, nil
+		}
+		r.stats.negativeHits.Add(1)
+		return entry{}, false, ne
 	}
 
-	return r.Lookup(address)
+	r.stats.misses.Add(1)
+	r.observer.Miss(address)
+	le, err := r.lookupEntry(ctx, address)
+	if err != nil {
+		r.logger.Debug("lookup failed", "address", address, "error", err, "stale", stale)
+	}
+	if err != nil && stale {
+		e.hit()
+		r.stats.staleHits.Add(1)
+		r.observer.Hit(address, true)
+		return e, true, nil
+	}
+	return le, false, err
+}
+
+// startPrefetch starts a background lookup of the address, unless one is already in flight.
+func (r *Simple) startPrefetch(address string) {
+	started := r.prefetch.start(address, func(ctx context.Context, address string) {
+		if _, err := r.LookupContext(ctx, address); err != nil {
+			r.logger.Debug("prefetch failed", "address", address, "error", err)
+		}
+	})
+	if started {
+		r.stats.prefetches.Add(1)
+	}
 }
 
 // Lookup returns a collection of IPs from a live lookup, and updates the cache.
 // Most callers should use one of the Fetch functions.
 func (r *Simple) Lookup(address string) ([]net.IP, error) {
-	ips, err := r.resolver(address)
+	return r.LookupContext(context.Background(), address)
+}
+
+// LookupContext returns a collection of IPs from a live lookup, honoring the Context,
+// and updates the cache.
+// Concurrent lookups of the same address are coalesced into a single resolver call.
+func (r *Simple) LookupContext(ctx context.Context, address string) ([]net.IP, error) {
+	e, err := r.lookupEntry(ctx, address)
+	return e.ips, err
+}
+
+// lookupEntry is LookupContext, but returns the entry.
+func (r *Simple) lookupEntry(ctx context.Context, address string) (entry, error) {
+	return r.flights.do(ctx, address, func(ctx context.Context) (entry, error) {
+		return r.lookup(ctx, address)
+	})
+}
+
+// lookup is the uncoalesced resolver call and cache update.
+func (r *Simple) lookup(ctx context.Context, address string) (entry, error) {
+	var (
+		ips   []net.IP
+		value any
+		ttl   time.Duration
+		err   error
+	)
+	r.observer.LookupStart(address)
+	start := time.Now()
+	if r.values != nil {
+		value, ttl, err = r.values(ctx, address)
+	} else {
+		ips, ttl, err = r.resolver(ctx, address)
+	}
+	took := time.Since(start)
+	r.stats.lookup(took, err)
+	r.observer.LookupFinish(address, ips, err, took)
 	if err != nil {
-		return nil, err
+		r.negatives.add(address, err)
+		r.lock.RLock()
+		if e, ok := r.cache[address]; ok {
+			e.failed(err)
+		}
+		r.lock.RUnlock()
+		return entry{}, err
 	}
+	r.negatives.remove(address)
+
+	now := time.Now()
+	e := entry{ips: ips, value: value, expires: r.ttl.expiry(ttl, now), refreshed: now, source: r.resolverName}
+	r.store(address, e)
+	return e, nil
+}
 
+// store upserts the entry, carrying over the metadata of any it replaces, and tells the Observer.
+func (r *Simple) store(address string, e entry) {
 	r.lock.Lock()
-	r.cache[address] = ips
+	old, existed := r.cache[address]
+	e.carry(old, existed)
+	r.cache[address] = e
 	r.lock.Unlock()
-	return ips, nil
+
+	observeStored(&r.observer, address, old.ips, existed, e.ips)
 }
 
 // Purge removes all entries from the cache.
 func (r *Simple) Purge() {
+	r.negatives.purge()
+
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	r.cache = make(map[string][]net.IP, 64)
+	r.cache = make(map[string]entry, 64)
 }
 
 // Refresh will crawl the cache and update their entries.
 // A timeout of 0 must mean no timeout.
 // RefreshSleepTime is checked for per-lookup intervals.
 // RefreshShuffle is checked.
+// If RecordTTL is enabled, only expired entries, and those without a TTL, are refreshed.
+// Errors are logged, rather than returned. See RefreshResult.
 func (r *Simple) Refresh(timeout time.Duration) {
-	var err error
-
-	if r.refreshType != RefreshBatch {
-		_, err = r.refresh(r, r.Lookup,
-			NewConfigOption(ConfigRefreshShuffle, r.refreshShuffle),
-			NewConfigOption(ConfigRefreshSleepTime, r.refreshSleepTime),
-			NewConfigOption(ConfigRefreshTimeout, timeout),
-		)
-	} else {
-		// batch
-		_, err = r.refresh(r, r.Lookup,
-			NewConfigOption(ConfigRefreshShuffle, r.refreshShuffle),
-			NewConfigOption(ConfigRefreshSleepTime, r.refreshSleepTime),
-			NewConfigOption(ConfigRefreshTimeout, timeout),
-			NewConfigOption(ConfigRefreshBatchSize, r.refreshBatchSize),
-		)
+	r.RefreshResult(timeout)
+}
+
+// RefreshResult is Refresh, but returns the RefreshResult of the pass, or an error
+// if the RefreshFunc failed, or was cancelled by Close. ErrorClosed is returned if the cache is closed.
+func (r *Simple) RefreshResult(timeout time.Duration) (RefreshResult, error) {
+	if !r.closer.enter() {
+		return RefreshResult{}, ErrorClosed
+	}
+	defer r.closer.exit()
+
+	var cache RefreshableCache = r
+	start := time.Now()
+	r.observer.RefreshStart()
+
+	if r.serveStale > 0 {
+		r.pruneStale(start)
+	}
+	if r.ttl.enabled {
+		cache = &dueCache{RefreshableCache: r, due: r.due}
 	}
+	tally := &refreshTally{RefreshableCache: cache, logger: r.logger}
+	ctx, cancel := passContext(r.closer.ctx, timeout)
+	defer cancel()
 
+	resolver := r.limiter.resolver(ctx, r.logger, tally.resolver(r.refreshLookup))
+	completed, err := r.refresh(tally, resolver, r.refreshOptions(r.closer.ctx, timeout)...)
+	if completed && err == nil {
+		r.endPass()
+	}
+	res := tally.result(time.Since(start), completed && err == nil)
+	r.observer.RefreshEnd(res.Took, res.Completed)
 	if err != nil {
-		panic(fmt.Errorf("error during RefreshFunc: %w", err))
+		return res, refreshError(r.logger, err)
 	}
+
+	r.stats.refresh(res.Took, res.Completed)
+	logResult(r.logger, res)
+	return res, nil
 }
 
-// Close will signal an in-progress Refresh, if any, to exit.
+// refreshLookup is Lookup, for Refresh passes. Close cancels it, and waits for the resolver call it
+// started, if any. Calls it joined are another caller's to wait for.
+func (r *Simple) refreshLookup(address string) ([]net.IP, error) {
+	e, err := r.flights.do(r.closer.ctx, address, func(ctx context.Context) (entry, error) {
+		if !r.closer.enter() {
+			return entry{}, ErrorClosed
+		}
+		defer r.closer.exit()
+		return r.lookup(ctx, address)
+	})
+	return e.ips, err
+}
+
+// refreshOptions returns the ConfigOptions for the RefreshFunc.
+func (r *Simple) refreshOptions(ctx context.Context, timeout time.Duration) []ConfigOption {
+	options := []ConfigOption{
+		NewConfigOption(ConfigRefreshShuffle, r.refreshShuffle),
+		NewConfigOption(ConfigRefreshSleepTime, r.refreshSleepTime),
+		NewConfigOption(ConfigRefreshTimeout, timeout),
+		NewConfigOption(ConfigRefreshContext, ctx),
+	}
+	if r.refreshType == RefreshBatch {
+		options = append(options, NewConfigOption(ConfigRefreshBatchSize, r.refreshBatchSize))
+	}
+	if r.refreshType == RefreshPopular {
+		options = append(options, r.popularity.option())
+	}
+	return options
+}
+
+// Close cancels any Refresh, and prefetches, in progress, and waits for them to finish.
+// Refreshes are refused thereafter.
 func (r *Simple) Close() error {
-	close(r.done)
+	r.closer.close()
+	r.prefetch.close()
 	return nil
 }
 
 // Add will upsert a collection into the cache.
 func (r *Simple) Add(address string, ips []net.IP) {
-	r.lock.Lock()
-	r.cache[address] = ips
-	r.lock.Unlock()
+	r.store(address, entry{ips: ips, refreshed: time.Now(), source: SourceAdd})
+}
+
+// addValue will upsert the value of a Cache into the cache.
+func (r *Simple) addValue(address string, value any) {
+	r.store(address, entry{value: value, refreshed: time.Now(), source: SourceAdd})
 }
 
-// Remove will remove a collection from the cache, if it exists.
+// Remove will remove a collection, or negatively-cached failure, from the cache, if it exists.
 func (r *Simple) Remove(address string) {
+	r.negatives.remove(address)
+
 	r.lock.Lock()
 	delete(r.cache, address)
 	r.lock.Unlock()
@@ -196,11 +438,29 @@ func (r *Simple) Remove(address string) {
 // Get will return a collection from the cache, also bool if
 // a collection was retrieved.
 func (r *Simple) Get(address string) ([]net.IP, bool) {
+	e, ok := r.get(address)
+	return e.ips, ok
+}
+
+// get returns the entry for the address, and true, or false if there is none.
+func (r *Simple) get(address string) (entry, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	e, ok := r.cache[address]
+	return e, ok
+}
+
+// GetEntry returns the Entry for the address, and true, or false if there is none.
+// The hit count of the entry is not updated.
+func (r *Simple) GetEntry(address string) (Entry, bool) {
 	r.lock.RLock()
-	v, ok := r.cache[address]
+	e, ok := r.cache[address]
 	r.lock.RUnlock()
 
-	return v, ok
+	if !ok {
+		return Entry{}, false
+	}
+	return e.export(), true
 }
 
 // Len will return the number of items in the cache.
@@ -226,3 +486,82 @@ func (r *Simple) Keys() []string {
 
 	return slices.Sorted(maps.Keys(r.cache))
 }
+
+// Expiring returns true if entries expire according to their record TTLs, and are
+// refreshed as they do.
+func (r *Simple) Expiring() bool {
+	return r.ttl.enabled
+}
+
+// NextExpiry returns the earliest expiry of any entry that is after the specified Time,
+// or the zero Time if there is none.
+func (r *Simple) NextExpiry(after time.Time) time.Time {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var next time.Time
+	for _, e := range r.cache {
+		if e.expires.After(after) && (next.IsZero() || e.expires.Before(next)) {
+			next = e.expires
+		}
+	}
+	return next
+}
+
+// due returns true if the address is in the cache, and due a Refresh.
+func (r *Simple) due(address string, now time.Time) bool {
+	r.lock.RLock()
+	e, ok := r.cache[address]
+	r.lock.RUnlock()
+
+	return ok && e.due(now)
+}
+
+// pruneStale removes the entries that are beyond the stale window at now.
+func (r *Simple) pruneStale(now time.Time) {
+	pruned := make(map[string]entry)
+
+	r.lock.Lock()
+	for k, e := range r.cache {
+		if e.defunct(r.serveStale, now) {
+			delete(r.cache, k)
+			pruned[k] = e
+		}
+	}
+	r.lock.Unlock()
+
+	for k, e := range pruned {
+		r.stats.evictions.Add(1)
+		r.observer.EntryEvicted(k, e.ips)
+	}
+}
+
+// endPass ends a completed Refresh pass for the entries, evicting any that have been idle for RefreshIdlePasses.
+func (r *Simple) endPass() {
+	idle := make(map[string]entry)
+
+	r.lock.Lock()
+	for k, e := range r.cache {
+		if e.endPass(r.popularity.idlePasses) {
+			delete(r.cache, k)
+			idle[k] = e
+		}
+	}
+	r.lock.Unlock()
+
+	for k, e := range idle {
+		r.stats.evictions.Add(1)
+		r.observer.EntryEvicted(k, e.ips)
+	}
+}
+
+// Stats returns a snapshot of the statistics of the cache.
+func (r *Simple) Stats() Stats {
+	return r.stats.snapshot()
+}
+
+// Observe adds the Observer, in addition to any configured via ConfigObserver,
+// returning a func to remove it.
+func (r *Simple) Observe(o Observer) func() {
+	return r.observer.add(o)
+}
@@ -1,7 +1,10 @@
 package cache
 
 import (
+	"context"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -151,3 +154,78 @@ func Test_SimpleEmptyCacheRefresh(t *testing.T) {
 		So(after, ShouldHappenWithin, 10*time.Millisecond, start)
 	})
 }
+
+func Test_SimpleLookupContext(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple is created with a ResolverContextFunc, the Context reaches the resolver", t, func() {
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverContextFunc(blockingResolver)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		ips, err := c.FetchContext(ctx, "dns.google.com")
+		So(ips, ShouldBeNil)
+		So(err, ShouldEqual, context.DeadlineExceeded)
+		So(c.Len(), ShouldEqual, 0)
+	})
+
+	Convey("When a Simple is created with a ResolverFunc, it is used for context lookups", t, func() {
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverFunc(func(string) ([]net.IP, error) {
+				return []net.IP{net.ParseIP("8.8.8.8")}, nil
+			})),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		ips, err := c.LookupContext(context.Background(), "dns.google.com")
+		So(err, ShouldBeNil)
+		So(ipsTov4(ips...), ShouldResemble, []string{"8.8.8.8"})
+		So(c.Contains("dns.google.com"), ShouldBeTrue)
+	})
+}
+
+// blockingResolver is a ResolverContextFunc that never resolves, and returns the Context error when it is done.
+func blockingResolver(ctx context.Context, address string) ([]net.IP, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func Test_SimpleFetchCoalesces(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple has many concurrent Fetch misses for the same address, there is one lookup", t, func() {
+		var (
+			calls atomic.Int32
+			wg    sync.WaitGroup
+		)
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverFunc(func(string) ([]net.IP, error) {
+				calls.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return []net.IP{net.ParseIP("8.8.8.8")}, nil
+			})),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for range 100 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Fetch("dns.google.com")
+			}()
+		}
+		wg.Wait()
+
+		So(calls.Load(), ShouldEqual, 1)
+		ips, ok := c.Get("dns.google.com")
+		So(ok, ShouldBeTrue)
+		So(ipsTov4(ips...), ShouldResemble, []string{"8.8.8.8"})
+	})
+}
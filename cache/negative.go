@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ConfigNegativeTTL is a time.Duration.
+	// Values > 0 enable negative caching: failed lookups are remembered, and their error is returned
+	// by Fetch without a live lookup until they expire. This is how long NXDOMAIN-style
+	// (not found) failures are remembered.
+	// 0 disables negative caching.
+	ConfigNegativeTTL = ConfigKey("NegativeTTL")
+	// ConfigNegativeTemporaryTTL is a time.Duration.
+	// This is how long temporary failures, including timeouts, are remembered, if negative caching is enabled.
+	// Defaults to a fifth of the NegativeTTL.
+	ConfigNegativeTemporaryTTL = ConfigKey("NegativeTemporaryTTL")
+
+	// NegativeNotFound is a NegativeKind for definitive failures, where the name does not exist.
+	NegativeNotFound = NegativeKind("NotFound")
+	// NegativeTemporary is a NegativeKind for temporary failures, including timeouts.
+	NegativeTemporary = NegativeKind("Temporary")
+
+	// negativeSweepLen is the number of negative entries at which expired ones are swept on insert.
+	negativeSweepLen = 1024
+)
+
+// NegativeKind is a string type for static consistency
+type NegativeKind string
+
+// NegativeError is returned by Fetch when a failed lookup of the address is negatively cached.
+// It unwraps to the original error, which is usually a *net.DNSError.
+type NegativeError struct {
+	Kind    NegativeKind
+	Expires time.Time
+	Err     error
+}
+
+// Error returns the message of the original error.
+func (e *NegativeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error.
+func (e *NegativeError) Unwrap() error {
+	return e.Err
+}
+
+// negativeKindOf returns the NegativeKind of the error, and false if the error should not be
+// negatively cached at all, such as when the lookup was canceled by the caller.
+func negativeKindOf(err error) (NegativeKind, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// the caller gave up, the resolver did not fail
+		return "", false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return NegativeNotFound, true
+	}
+	return NegativeTemporary, true
+}
+
+// negativeCache is a goro-safe collection of failed lookups, shared by the caches.
+// The zero value is disabled.
+type negativeCache struct {
+	ttl          time.Duration
+	temporaryTTL time.Duration
+
+	lock    sync.Mutex
+	entries map[string]*NegativeError
+}
+
+// config is an internal validator and applier for the ConfigNegative* ConfigOptions.
+// The bool returned is false if the option is not a ConfigNegative* option.
+func (n *negativeCache) config(opt ConfigOption) (bool, error) {
+	switch opt.Key {
+	case ConfigNegativeTTL:
+		if v, ok := opt.Value.(time.Duration); ok {
+			n.ttl = v
+			if n.temporaryTTL == 0 {
+				n.temporaryTTL = v / 5
+			}
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigNegativeTemporaryTTL:
+		if v, ok := opt.Value.(time.Duration); ok {
+			n.temporaryTTL = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// get returns the unexpired NegativeError for the address, if any.
+func (n *negativeCache) get(address string) (*NegativeError, bool) {
+	if n.ttl <= 0 {
+		return nil, false
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	e, ok := n.entries[address]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.Expires) {
+		delete(n.entries, address)
+		return nil, false
+	}
+	return e, true
+}
+
+// add records the failed lookup of the address, if negative caching is enabled and the error is cacheable.
+func (n *negativeCache) add(address string, err error) {
+	if n.ttl <= 0 {
+		return
+	}
+	kind, ok := negativeKindOf(err)
+	if !ok {
+		return
+	}
+
+	ttl := n.ttl
+	if kind == NegativeTemporary {
+		ttl = n.temporaryTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.entries == nil {
+		n.entries = make(map[string]*NegativeError)
+	} else if len(n.entries) >= negativeSweepLen {
+		for k, e := range n.entries {
+			if now.After(e.Expires) {
+				delete(n.entries, k)
+			}
+		}
+	}
+	n.entries[address] = &NegativeError{
+		Kind:    kind,
+		Expires: now.Add(ttl),
+		Err:     err,
+	}
+}
+
+// remove forgets the failed lookup of the address, if any.
+func (n *negativeCache) remove(address string) {
+	n.lock.Lock()
+	delete(n.entries, address)
+	n.lock.Unlock()
+}
+
+// purge forgets all of the failed lookups.
+func (n *negativeCache) purge() {
+	n.lock.Lock()
+	n.entries = nil
+	n.lock.Unlock()
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_NegativeKindOf(t *testing.T) {
+	Convey("When errors are classified, the expected NegativeKinds are returned", t, func() {
+		k, ok := negativeKindOf(&net.DNSError{Err: "no such host", Name: "invalid.viki.io", IsNotFound: true})
+		So(ok, ShouldBeTrue)
+		So(k, ShouldEqual, NegativeNotFound)
+
+		k, ok = negativeKindOf(&net.DNSError{Err: "i/o timeout", Name: "invalid.viki.io", IsTimeout: true, IsTemporary: true})
+		So(ok, ShouldBeTrue)
+		So(k, ShouldEqual, NegativeTemporary)
+
+		k, ok = negativeKindOf(errors.New("boom"))
+		So(ok, ShouldBeTrue)
+		So(k, ShouldEqual, NegativeTemporary)
+
+		_, ok = negativeKindOf(context.Canceled)
+		So(ok, ShouldBeFalse)
+		_, ok = negativeKindOf(context.DeadlineExceeded)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func Test_SimpleNegativeCache(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple has negative caching enabled, NXDOMAINs are remembered until they expire", t, func() {
+		nxErr := &net.DNSError{Err: "no such host", Name: "invalid.viki.io", IsNotFound: true}
+		fake := fakeResolver{err: nxErr}
+		fake.fail.Store(true)
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigNegativeTTL, 30*time.Millisecond),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		_, err = c.Fetch("invalid.viki.io")
+		So(err, ShouldEqual, nxErr)
+
+		_, err = c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 1)
+		var ne *NegativeError
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(ne.Kind, ShouldEqual, NegativeNotFound)
+		var dnsErr *net.DNSError
+		So(errors.As(err, &dnsErr), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "lookup invalid.viki.io: no such host")
+		So(c.Len(), ShouldEqual, 0)
+
+		time.Sleep(40 * time.Millisecond)
+		c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 2)
+
+		Convey("and Remove forgets them", func() {
+			c.Remove("invalid.viki.io")
+			c.Fetch("invalid.viki.io")
+			So(fake.calls.Load(), ShouldEqual, 3)
+		})
+	})
+
+	Convey("When a Simple has negative caching enabled, temporary failures are remembered for less time", t, func() {
+		fake := fakeResolver{err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}
+		fake.fail.Store(true)
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigNegativeTTL, time.Minute),
+			NewConfigOption(ConfigNegativeTemporaryTTL, 10*time.Millisecond),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("invalid.viki.io")
+		_, err = c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 1)
+		var ne *NegativeError
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(ne.Kind, ShouldEqual, NegativeTemporary)
+
+		time.Sleep(20 * time.Millisecond)
+		c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When a Simple has negative caching disabled, every Fetch is a lookup", t, func() {
+		fake := fakeResolver{err: errors.New("boom")}
+		fake.fail.Store(true)
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("invalid.viki.io")
+		c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 2)
+	})
+}
+
+func Test_LRUNegativeCache(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When an LRU has negative caching enabled, failures are remembered until Purge", t, func() {
+		fake := fakeResolver{err: errors.New("boom")}
+		fake.fail.Store(true)
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigNegativeTTL, time.Minute),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("invalid.viki.io")
+		c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 1)
+
+		c.Purge()
+		c.Fetch("invalid.viki.io")
+		So(fake.calls.Load(), ShouldEqual, 2)
+
+		So(c.config(NewConfigOption(ConfigNegativeTTL, "1m")), ShouldBeError)
+		So(c.config(NewConfigOption(ConfigNegativeTemporaryTTL, 5)), ShouldBeError)
+	})
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ConfigObserver is an Observer.
+	// The Observer is told of the lifecycle events of the cache, as they happen.
+	ConfigObserver = ConfigKey("Observer")
+)
+
+// Observer is told of the lifecycle events of a cache, as they happen.
+// Callbacks are made synchronously, sometimes while the cache is locked, so implementations
+// must be goro-safe, should return quickly, and must not call the cache.
+// The IP slices passed must not be modified.
+// Embed NoopObserver to implement only the callbacks of interest.
+type Observer interface {
+	// Hit is called when a Fetch is served from the cache. Stale is true if the entry was stale,
+	// served because the live lookup failed.
+	Hit(address string, stale bool)
+	// Miss is called when a Fetch requires a live lookup. Negatively-cached failures are not misses.
+	Miss(address string)
+	// LookupStart is called before each live lookup, including those made by Refresh.
+	LookupStart(address string)
+	// LookupFinish is called after each live lookup, with its results, and how long it took.
+	LookupFinish(address string, ips []net.IP, err error, took time.Duration)
+	// EntryAdded is called when an entry is added for an address that had none.
+	EntryAdded(address string, ips []net.IP)
+	// EntryChanged is called when the entry for an address is replaced by a different set of IPs.
+	// Replacements with the same set of IPs, in any order, are not changes.
+	EntryChanged(address string, old, new []net.IP)
+	// EntryEvicted is called when the cache itself removes an entry, due to size or age.
+	// Removes and Purges are not evictions.
+	EntryEvicted(address string, ips []net.IP)
+	// RefreshStart is called when a Refresh pass starts.
+	RefreshStart()
+	// RefreshEnd is called when a Refresh pass ends, with how long it took and whether it completed.
+	RefreshEnd(took time.Duration, completed bool)
+}
+
+// ObservableCache is an interface that caches which can add Observers after instantiation implement.
+type ObservableCache interface {
+	// Observe adds the Observer, returning a func to remove it. The func is safe to call more than once.
+	Observe(o Observer) (remove func())
+}
+
+// NoopObserver is an Observer that does nothing, suitable for embedding.
+type NoopObserver struct{}
+
+// Hit does nothing.
+func (NoopObserver) Hit(string, bool) {}
+
+// Miss does nothing.
+func (NoopObserver) Miss(string) {}
+
+// LookupStart does nothing.
+func (NoopObserver) LookupStart(string) {}
+
+// LookupFinish does nothing.
+func (NoopObserver) LookupFinish(string, []net.IP, error, time.Duration) {}
+
+// EntryAdded does nothing.
+func (NoopObserver) EntryAdded(string, []net.IP) {}
+
+// EntryChanged does nothing.
+func (NoopObserver) EntryChanged(string, []net.IP, []net.IP) {}
+
+// EntryEvicted does nothing.
+func (NoopObserver) EntryEvicted(string, []net.IP) {}
+
+// RefreshStart does nothing.
+func (NoopObserver) RefreshStart() {}
+
+// RefreshEnd does nothing.
+func (NoopObserver) RefreshEnd(time.Duration, bool) {}
+
+// observerList is a goro-safe Observer that fans out to a changeable list of Observers.
+// Changes are copy-on-write, so callbacks are lock-free. The zero value is ready to use.
+type observerList struct {
+	lock sync.Mutex // serializes changes
+	list atomic.Pointer[[]*observed]
+}
+
+// observed is an Observer in an observerList, with an identity for removal.
+type observed struct {
+	Observer
+}
+
+// add adds the Observer, returning a func to remove it.
+func (l *observerList) add(o Observer) func() {
+	e := &observed{Observer: o}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	list := append(slices.Clone(l.observers()), e)
+	l.list.Store(&list)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			list := slices.DeleteFunc(slices.Clone(l.observers()), func(x *observed) bool { return x == e })
+			l.list.Store(&list)
+		})
+	}
+}
+
+// observers returns the current list, which must not be modified.
+func (l *observerList) observers() []*observed {
+	if p := l.list.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// Hit calls Hit on every Observer.
+func (l *observerList) Hit(address string, stale bool) {
+	for _, o := range l.observers() {
+		o.Hit(address, stale)
+	}
+}
+
+// Miss calls Miss on every Observer.
+func (l *observerList) Miss(address string) {
+	for _, o := range l.observers() {
+		o.Miss(address)
+	}
+}
+
+// LookupStart calls LookupStart on every Observer.
+func (l *observerList) LookupStart(address string) {
+	for _, o := range l.observers() {
+		o.LookupStart(address)
+	}
+}
+
+// LookupFinish calls LookupFinish on every Observer.
+func (l *observerList) LookupFinish(address string, ips []net.IP, err error, took time.Duration) {
+	for _, o := range l.observers() {
+		o.LookupFinish(address, ips, err, took)
+	}
+}
+
+// EntryAdded calls EntryAdded on every Observer.
+func (l *observerList) EntryAdded(address string, ips []net.IP) {
+	for _, o := range l.observers() {
+		o.EntryAdded(address, ips)
+	}
+}
+
+// EntryChanged calls EntryChanged on every Observer.
+func (l *observerList) EntryChanged(address string, old, new []net.IP) {
+	for _, o := range l.observers() {
+		o.EntryChanged(address, old, new)
+	}
+}
+
+// EntryEvicted calls EntryEvicted on every Observer.
+func (l *observerList) EntryEvicted(address string, ips []net.IP) {
+	for _, o := range l.observers() {
+		o.EntryEvicted(address, ips)
+	}
+}
+
+// RefreshStart calls RefreshStart on every Observer.
+func (l *observerList) RefreshStart() {
+	for _, o := range l.observers() {
+		o.RefreshStart()
+	}
+}
+
+// RefreshEnd calls RefreshEnd on every Observer.
+func (l *observerList) RefreshEnd(took time.Duration, completed bool) {
+	for _, o := range l.observers() {
+		o.RefreshEnd(took, completed)
+	}
+}
+
+// observeStored tells the Observer of an entry stored for the address, which replaced
+// old if existed.
+func observeStored(o Observer, address string, old []net.IP, existed bool, ips []net.IP) {
+	switch {
+	case !existed:
+		o.EntryAdded(address, ips)
+	case !SameIPs(old, ips):
+		o.EntryChanged(address, old, ips)
+	}
+}
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// eventObserver is an Observer that records its events as strings.
+type eventObserver struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (o *eventObserver) add(format string, a ...any) {
+	o.lock.Lock()
+	o.events = append(o.events, fmt.Sprintf(format, a...))
+	o.lock.Unlock()
+}
+
+// drain returns the events recorded since the last drain.
+func (o *eventObserver) drain() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	e := o.events
+	o.events = nil
+	return e
+}
+
+func (o *eventObserver) Hit(address string, stale bool) { o.add("hit %s %t", address, stale) }
+func (o *eventObserver) Miss(address string)            { o.add("miss %s", address) }
+func (o *eventObserver) LookupStart(address string)     { o.add("start %s", address) }
+func (o *eventObserver) LookupFinish(address string, ips []net.IP, err error, took time.Duration) {
+	o.add("finish %s %v %v", address, ipsTov4(ips...), err != nil)
+}
+func (o *eventObserver) EntryAdded(address string, ips []net.IP) {
+	o.add("added %s %v", address, ipsTov4(ips...))
+}
+func (o *eventObserver) EntryChanged(address string, old, new []net.IP) {
+	o.add("changed %s %v %v", address, ipsTov4(old...), ipsTov4(new...))
+}
+func (o *eventObserver) EntryEvicted(address string, ips []net.IP) {
+	o.add("evicted %s %v", address, ipsTov4(ips...))
+}
+func (o *eventObserver) RefreshStart() { o.add("refresh") }
+func (o *eventObserver) RefreshEnd(took time.Duration, completed bool) {
+	o.add("refreshed %t", completed)
+}
+
+func Test_SimpleObserver(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple has an Observer, it is told of the lifecycle events", t, func() {
+		var (
+			fake = fakeResolver{ttl: TTLUnknown}
+			o    eventObserver
+		)
+		fake.answer.Store("8.8.8.8")
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigObserver, &o),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		c.Fetch("dns.google.com")
+		So(o.drain(), ShouldResemble, []string{
+			"miss dns.google.com",
+			"start dns.google.com",
+			"finish dns.google.com [8.8.8.8] false",
+			"added dns.google.com [8.8.8.8]",
+			"hit dns.google.com false",
+		})
+
+		c.Refresh(0) // unchanged
+		So(o.drain(), ShouldResemble, []string{
+			"refresh",
+			"start dns.google.com",
+			"finish dns.google.com [8.8.8.8] false",
+			"refreshed true",
+		})
+
+		fake.answer.Store("8.8.4.4")
+		c.Refresh(0)
+		So(o.drain(), ShouldContain, "changed dns.google.com [8.8.8.8] [8.8.4.4]")
+
+		c.Add("dns.google.com", []net.IP{net.ParseIP("8.8.4.4")}) // unchanged
+		c.Add("localhost", []net.IP{net.ParseIP("127.0.0.1")})
+		c.Remove("localhost") // not an eviction
+		So(o.drain(), ShouldResemble, []string{"added localhost [127.0.0.1]"})
+	})
+
+	Convey("When a Simple prunes entries beyond the stale window, the Observer is told of the evictions", t, func() {
+		var (
+			fake = fakeResolver{ttl: time.Millisecond}
+			o    eventObserver
+		)
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigServeStale, time.Millisecond),
+			NewConfigOption(ConfigRefreshType, RefreshOff),
+			NewConfigOption(ConfigObserver, &o),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		time.Sleep(10 * time.Millisecond)
+		o.drain()
+
+		c.Refresh(0)
+		So(o.drain(), ShouldContain, "evicted dns.google.com [8.8.8.8]")
+		So(c.Len(), ShouldEqual, 0)
+	})
+
+	Convey("When an Observer is not an Observer, an error is returned", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigObserver, "observer"))
+		So(err, ShouldEqual, ConfigObserver.Error())
+	})
+}
+
+func Test_LRUObserver(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When an LRU has an Observer, it is told of the evictions, and only the evictions", t, func() {
+		var o eventObserver
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 2),
+			NewConfigOption(ConfigObserver, &o),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for i := range 3 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP(fmt.Sprintf("127.0.0.%d", i))})
+		}
+		c.Remove("2.localhost")
+		c.Purge()
+
+		events := o.drain()
+		So(slices.Contains(events, "evicted 0.localhost [127.0.0.0]"), ShouldBeTrue)
+		So(events, ShouldHaveLength, 4) // 3 adds, 1 eviction
+	})
+}
+
+// expirableGoros returns how many expirable.LRU goros are running. Those yet to start are not,
+// as leaktest ignores them until they have.
+func expirableGoros() int {
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	for n == len(buf) {
+		buf = make([]byte, 2*len(buf))
+		n = runtime.Stack(buf, true)
+	}
+
+	var goros int
+	for _, g := range strings.Split(string(buf[:n]), "\n\n") {
+		if strings.Contains(g, "expirable.NewLRU[...].func1()") && !strings.Contains(g, "runtime.goexit") {
+			goros++
+		}
+	}
+	return goros
+}
+
+// awaitExpirableGoro waits, for up to a second, for the expirable.LRU goros running to number more than
+// before, so that the leaktests of later tests don't mistake a goro that starts late for one of theirs.
+func awaitExpirableGoro(before int) {
+	for deadline := time.Now().Add(time.Second); expirableGoros() <= before && time.Now().Before(deadline); {
+		runtime.Gosched()
+	}
+}
+
+func Test_ExpirableLRUObserver(t *testing.T) {
+
+	// Cannot leaktest expirable.LRU. https://github.com/hashicorp/golang-lru/blob/1ecdc13547b564bf736db9161ed89f1864010108/expirable/expirable_lru.go#L53
+	Convey("When an expirable LRU has an Observer, it is told of the evictions", t, func() {
+		var o eventObserver
+		goros := expirableGoros()
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 2),
+			NewConfigOption(ConfigItemTTL, time.Hour),
+			NewConfigOption(ConfigObserver, &o),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		awaitExpirableGoro(goros)
+
+		for i := range 3 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{net.ParseIP(fmt.Sprintf("127.0.0.%d", i))})
+		}
+		c.Purge()
+
+		So(o.drain(), ShouldContain, "evicted 0.localhost [127.0.0.0]")
+	})
+}
+
+func Test_Observe(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When Observers are added to a cache, they are told of events until removed", t, func() {
+		var configured, added eventObserver
+		c, err := NewSimple(NewConfigOption(ConfigObserver, &configured))
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		var oc ObservableCache = c
+		remove := oc.Observe(&added)
+
+		c.Add("localhost", []net.IP{net.ParseIP("127.0.0.1")})
+		remove()
+		remove() // safe
+		c.Add("localhost", []net.IP{net.ParseIP("127.0.0.2")})
+
+		So(added.drain(), ShouldResemble, []string{"added localhost [127.0.0.1]"})
+		So(configured.drain(), ShouldResemble, []string{
+			"added localhost [127.0.0.1]",
+			"changed localhost [127.0.0.1] [127.0.0.2]",
+		})
+	})
+}
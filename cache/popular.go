@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"fmt"
+)
+
+const (
+	// ConfigRefreshMinHits is an int.
+	// When the RefreshPopular RefreshType is used, only entries hit at least this many times
+	// since the last completed Refresh pass are refreshed. Must be > 0.
+	// Defaults to 1.
+	ConfigRefreshMinHits = ConfigKey("RefreshMinHits")
+	// ConfigRefreshIdlePasses is an int.
+	// For values > 0, entries not hit during this many consecutive completed Refresh passes are evicted at the
+	// end of the last of them, regardless of RefreshType.
+	// 0 disables idle eviction.
+	ConfigRefreshIdlePasses = ConfigKey("RefreshIdlePasses")
+
+	// RefreshPopular is a RefreshType that refreshes, one-at-a-time, only the entries hit at least
+	// RefreshMinHits times since the last completed Refresh pass, so that cold entries don't use up the refresh budget.
+	// Cold entries are left to expire, or to be evicted by RefreshIdlePasses.
+	RefreshPopular = RefreshType("RefreshPopular")
+)
+
+// popularityConfig is the popularity configuration shared by the caches.
+type popularityConfig struct {
+	minHits    int // 0 is the default, 1
+	idlePasses int
+}
+
+// config is an internal validator and applier for the ConfigRefreshMinHits and ConfigRefreshIdlePasses ConfigOptions.
+// The bool returned is false if the option is neither.
+func (p *popularityConfig) config(opt ConfigOption) (bool, error) {
+	switch opt.Key {
+	case ConfigRefreshMinHits:
+		if v, ok := opt.Value.(int); ok && v > 0 {
+			p.minHits = v
+		} else if ok {
+			return true, fmt.Errorf("%s must be > 0", opt.Key)
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigRefreshIdlePasses:
+		if v, ok := opt.Value.(int); ok && v >= 0 {
+			p.idlePasses = v
+		} else if ok {
+			return true, fmt.Errorf("%s must be >= 0", opt.Key)
+		} else {
+			return true, opt.Key.Error()
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// option returns the ConfigRefreshMinHits ConfigOption for the RefreshFunc.
+func (p *popularityConfig) option() ConfigOption {
+	return NewConfigOption(ConfigRefreshMinHits, max(p.minHits, 1))
+}
+
+// keyCounter is a RefreshableCache that counts the keys returned by Keys, e.g. a refreshTally, which
+// wrappers that filter them must correct.
+type keyCounter interface {
+	countKeys(n int)
+}
+
+// popularCache is a RefreshableCache whose Keys are only those of entries with at least minHits recent hits.
+type popularCache struct {
+	RefreshableCache
+	minHits uint64
+}
+
+// Keys returns the keys of the popular entries, correcting the count of a keyCounter.
+// If the cache is not an EntryCache, all of the keys are returned.
+func (p *popularCache) Keys() []string {
+	var (
+		keys = p.RefreshableCache.Keys()
+		out  = keys[:0]
+	)
+	if _, ok := p.RefreshableCache.(EntryCache); !ok {
+		return keys
+	}
+	for _, k := range keys {
+		if e, ok := getEntry(p.RefreshableCache, k); ok && e.RecentHits >= p.minHits {
+			out = append(out, k)
+		}
+	}
+	if kc, ok := p.RefreshableCache.(keyCounter); ok {
+		kc.countKeys(len(out))
+	}
+	return out
+}
+
+// GetEntry returns the Entry for the address, if the wrapped cache is an EntryCache.
+func (p *popularCache) GetEntry(address string) (Entry, bool) {
+	return getEntry(p.RefreshableCache, address)
+}
+
+// PopularRefresh is LinearRefresh, but only of the entries hit at least RefreshMinHits times since the last
+// completed Refresh pass. If the cache is not an EntryCache, it is LinearRefresh.
+// By default, RefreshMinHits is 1, and the LinearRefresh defaults apply.
+func PopularRefresh(cache RefreshableCache, resolver ResolverFunc, options ...ConfigOption) (bool, error) {
+	var (
+		minHits = 1
+		linear  = make([]ConfigOption, 0, len(options))
+	)
+	for _, o := range options {
+		if o.Key != ConfigRefreshMinHits {
+			linear = append(linear, o)
+			continue
+		}
+		if v, ok := o.Value.(int); ok && v > 0 {
+			minHits = v
+		} else if ok {
+			return false, fmt.Errorf("%s must be > 0", o.Key)
+		} else {
+			return false, o.Key.Error()
+		}
+	}
+
+	return LinearRefresh(&popularCache{RefreshableCache: cache, minHits: uint64(minHits)}, resolver, linear...)
+}
+
+// endPass ends a completed Refresh pass for the entry, returning true if it has now gone unhit for idlePasses passes,
+// if idlePasses > 0.
+func (e *entry) endPass(idlePasses int) bool {
+	if e.meta == nil {
+		return false
+	}
+	if e.meta.recent.Swap(0) > 0 {
+		e.meta.idle.Store(0)
+		return false
+	}
+	idle := e.meta.idle.Add(1)
+	return idlePasses > 0 && idle >= int64(idlePasses)
+}
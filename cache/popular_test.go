@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// lookupLog is a ResolverTTLFunc source that records the addresses it looks up.
+type lookupLog struct {
+	lock      sync.Mutex
+	addresses []string
+}
+
+// resolver returns a ResolverTTLFunc that records the address, and answers 8.8.8.8.
+func (l *lookupLog) resolver() ResolverTTLFunc {
+	return func(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+		l.lock.Lock()
+		l.addresses = append(l.addresses, address)
+		l.lock.Unlock()
+		return []net.IP{net.ParseIP("8.8.8.8")}, TTLUnknown, nil
+	}
+}
+
+// drain returns, and forgets, the addresses looked up, sorted.
+func (l *lookupLog) drain() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	a := l.addresses
+	l.addresses = nil
+	slices.Sort(a)
+	return a
+}
+
+func Test_RefreshPopular(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a cache uses RefreshPopular, only the entries hit since the last pass are refreshed", t, func() {
+		var lookups lookupLog
+
+		s, err := NewSimple(
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshType, RefreshPopular),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		defer s.Close()
+
+		l, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshType, RefreshPopular),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRefreshMinHits, 2),
+		)
+		So(err, ShouldBeNil)
+		defer l.Close()
+
+		for _, c := range []entryCache{s, l} {
+			for _, a := range []string{"hot.com", "hot.com", "hot.com", "warm.com", "warm.com", "cold.com"} {
+				c.Fetch(a)
+			}
+			lookups.drain()
+		}
+
+		res, err := s.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Keys, ShouldEqual, 2)
+		So(lookups.drain(), ShouldResemble, []string{"hot.com", "warm.com"})
+		res, err = l.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Keys, ShouldEqual, 1)
+		So(lookups.drain(), ShouldResemble, []string{"hot.com"})
+
+		// nothing has been hit since
+		s.Refresh(0)
+		l.Refresh(0)
+		So(lookups.drain(), ShouldBeEmpty)
+
+		e, ok := s.GetEntry("hot.com")
+		So(ok, ShouldBeTrue)
+		So(e.Hits, ShouldEqual, 2)
+		So(e.RecentHits, ShouldBeZeroValue)
+		So(e.IdlePasses, ShouldEqual, 1)
+		So(s.Len(), ShouldEqual, 3) // cold entries are not evicted by default
+	})
+
+	Convey("When a RefreshPopular pass does not complete, the recent hits are kept for the next", t, func() {
+		var lookups lookupLog
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshType, RefreshPopular),
+			NewConfigOption(ConfigRefreshSleepTime, time.Second),
+			NewConfigOption(ConfigRefreshIdlePasses, 1),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("hot.com")
+		c.Fetch("hot.com")
+		c.Fetch("warm.com")
+		c.Fetch("warm.com")
+
+		res, err := c.RefreshResult(10 * time.Millisecond)
+		So(err, ShouldBeNil)
+		So(res.Completed, ShouldBeFalse)
+		So(c.Len(), ShouldEqual, 2)
+		for _, a := range []string{"hot.com", "warm.com"} {
+			e, ok := c.GetEntry(a)
+			So(ok, ShouldBeTrue)
+			So(e.RecentHits, ShouldEqual, 1)
+			So(e.IdlePasses, ShouldBeZeroValue)
+		}
+	})
+
+	Convey("When a cache has RefreshIdlePasses, entries not hit within that many passes are evicted", t, func() {
+		var (
+			lookups lookupLog
+			o       eventObserver
+		)
+
+		for _, refreshType := range []RefreshType{RefreshPopular, RefreshOff} {
+			c, err := NewSimple(
+				NewConfigOption(ConfigResolver, lookups.resolver()),
+				NewConfigOption(ConfigRefreshType, refreshType),
+				NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+				NewConfigOption(ConfigRefreshIdlePasses, 2),
+				NewConfigOption(ConfigObserver, &o),
+			)
+			So(err, ShouldBeNil)
+			defer c.Close()
+
+			c.Fetch("hot.com")
+			c.Fetch("cold.com")
+			c.Refresh(0)
+			So(c.Len(), ShouldEqual, 2)
+
+			c.Fetch("hot.com")
+			o.drain()
+			c.Refresh(0)
+			So(c.Len(), ShouldEqual, 1)
+			So(o.drain(), ShouldContain, "evicted cold.com [8.8.8.8]")
+			So(c.Stats().Evictions, ShouldEqual, 1)
+
+			_, ok := c.Get("hot.com")
+			So(ok, ShouldBeTrue)
+		}
+
+		l, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, lookups.resolver()),
+			NewConfigOption(ConfigRefreshType, RefreshPopular),
+			NewConfigOption(ConfigRefreshIdlePasses, 1),
+		)
+		So(err, ShouldBeNil)
+		defer l.Close()
+
+		l.Fetch("cold.com")
+		l.Refresh(0)
+		So(l.Len(), ShouldEqual, 0)
+		So(l.Stats().Evictions, ShouldEqual, 1)
+	})
+
+	Convey("When PopularRefresh is passed a cache without entries, it refreshes every key", t, func() {
+		var lookups lookupLog
+		resolver := func(address string) ([]net.IP, error) {
+			ips, _, err := lookups.resolver()(context.Background(), address)
+			return ips, err
+		}
+
+		ok, err := PopularRefresh(&evictingCache{keys: []string{"a.com", "b.com"}}, resolver,
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRefreshMinHits, 5),
+		)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(lookups.drain(), ShouldResemble, []string{"a.com", "b.com"})
+	})
+
+	Convey("When the popularity options are invalid, an error is returned", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigRefreshMinHits, 0))
+		So(err, ShouldBeError)
+		_, err = NewSimple(NewConfigOption(ConfigRefreshMinHits, "1"))
+		So(err, ShouldEqual, ConfigRefreshMinHits.Error())
+		_, err = NewLRU(NewConfigOption(ConfigSize, 10), NewConfigOption(ConfigRefreshIdlePasses, -1))
+		So(err, ShouldBeError)
+		_, err = NewLRU(NewConfigOption(ConfigSize, 10), NewConfigOption(ConfigRefreshIdlePasses, time.Second))
+		So(err, ShouldEqual, ConfigRefreshIdlePasses.Error())
+
+		_, err = PopularRefresh(emptyCache{}, nil, NewConfigOption(ConfigRefreshMinHits, -1))
+		So(err, ShouldBeError)
+	})
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ConfigPrefetch is a float64.
+	// For values > 0, a Fetch hit on an entry with less than this fraction of its lifetime remaining
+	// starts a background lookup of the address, so that popular entries are refreshed before they expire.
+	// Only entries that expire, due to RecordTTL or, for LRUs, ItemTTL, are prefetched.
+	// Must be < 1. 0 disables prefetching.
+	ConfigPrefetch = ConfigKey("Prefetch")
+)
+
+// prefetcher starts background lookups of entries nearing expiry, one at a time per address.
+// The zero value is ready to use, and prefetches nothing.
+type prefetcher struct {
+	fraction float64
+
+	lock     sync.Mutex
+	inflight map[string]struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// config is an internal validator and applier for the ConfigPrefetch ConfigOption.
+// The bool returned is false if the option is not ConfigPrefetch.
+func (p *prefetcher) config(opt ConfigOption) (bool, error) {
+	if opt.Key != ConfigPrefetch {
+		return false, nil
+	}
+	if v, ok := opt.Value.(float64); ok && v >= 0 && v < 1 {
+		p.fraction = v
+	} else if ok {
+		return true, fmt.Errorf("%s must be >= 0 and < 1", opt.Key)
+	} else {
+		return true, opt.Key.Error()
+	}
+	return true, nil
+}
+
+// due returns true if the entry has less than the prefetch fraction of its lifetime remaining at now.
+func (p *prefetcher) due(e *entry, now time.Time) bool {
+	if p.fraction <= 0 || e.expires.IsZero() {
+		return false
+	}
+	life := e.expires.Sub(e.refreshed)
+	return life > 0 && e.expires.Sub(now) < time.Duration(float64(life)*p.fraction)
+}
+
+// start calls lookup for the address in a new goro, returning true, unless one is already in flight
+// for it, or the prefetcher is closed. The Context passed to lookup is cancelled by close.
+func (p *prefetcher) start(address string, lookup func(ctx context.Context, address string)) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return false
+	}
+	if _, ok := p.inflight[address]; ok {
+		return false
+	}
+	if p.inflight == nil {
+		p.inflight = make(map[string]struct{})
+		p.ctx, p.cancel = context.WithCancel(context.Background())
+	}
+	p.inflight[address] = struct{}{}
+
+	p.wg.Add(1)
+	go func(ctx context.Context) {
+		defer p.wg.Done()
+		lookup(ctx, address)
+
+		p.lock.Lock()
+		delete(p.inflight, address)
+		p.lock.Unlock()
+	}(p.ctx)
+	return true
+}
+
+// close cancels any prefetches in flight, and waits for them to finish. No more are started.
+func (p *prefetcher) close() {
+	p.lock.Lock()
+	p.closed = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.lock.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_PrefetchDue(t *testing.T) {
+	Convey("When a prefetcher checks whether an entry is due, the fraction of its lifetime remaining is compared", t, func() {
+		var (
+			now = time.Now()
+			p   = prefetcher{fraction: 0.1}
+			e   = entry{refreshed: now.Add(-95 * time.Second), expires: now.Add(5 * time.Second)}
+		)
+		So(p.due(&e, now), ShouldBeTrue)
+
+		e.refreshed = now.Add(-50 * time.Second)
+		So(p.due(&e, now), ShouldBeTrue) // 5s of 55s remaining
+		e.refreshed = now.Add(-5 * time.Second)
+		So(p.due(&e, now), ShouldBeFalse) // 5s of 10s remaining
+
+		So(p.due(&entry{refreshed: now}, now), ShouldBeFalse) // never expires
+		So((&prefetcher{}).due(&e, now.Add(4*time.Second)), ShouldBeFalse)
+	})
+}
+
+func Test_SimplePrefetch(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple prefetches, hits on entries nearing expiry start one background lookup", t, func() {
+		var (
+			calls   atomic.Int32
+			release = make(chan struct{})
+		)
+		resolver := func(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+			if calls.Add(1) > 1 {
+				<-release
+			}
+			return []net.IP{net.ParseIP("8.8.8.8")}, 100 * time.Millisecond, nil
+		}
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(resolver)),
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigPrefetch, 0.5),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		c.Fetch("dns.google.com") // not due
+		So(c.Stats().Prefetches, ShouldBeZeroValue)
+
+		time.Sleep(60 * time.Millisecond)
+		for range 10 {
+			ips, err := c.Fetch("dns.google.com")
+			So(err, ShouldBeNil)
+			So(ips, ShouldHaveLength, 1)
+		}
+		So(waitFor(func() bool { return calls.Load() == 2 }), ShouldBeTrue)
+		So(c.Stats().Prefetches, ShouldEqual, 1)
+		So(c.Stats().Misses, ShouldEqual, 1)
+
+		close(release)
+		So(waitFor(func() bool {
+			e, _ := c.GetEntry("dns.google.com")
+			return time.Until(e.Expires) > 50*time.Millisecond
+		}), ShouldBeTrue)
+		So(calls.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When a Simple is closed, prefetches in flight are cancelled", t, func() {
+		var calls atomic.Int32
+		resolver := func(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+			if calls.Add(1) > 1 {
+				<-ctx.Done()
+				return nil, TTLUnknown, ctx.Err()
+			}
+			return []net.IP{net.ParseIP("8.8.8.8")}, 10 * time.Millisecond, nil
+		}
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(resolver)),
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigPrefetch, 0.9),
+		)
+		So(err, ShouldBeNil)
+
+		c.Fetch("dns.google.com")
+		time.Sleep(2 * time.Millisecond)
+		c.Fetch("dns.google.com")
+		So(waitFor(func() bool { return calls.Load() == 2 }), ShouldBeTrue)
+
+		So(c.Close(), ShouldBeNil) // waits for the prefetch
+	})
+
+	Convey("When the Prefetch option is invalid, an error is returned", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigPrefetch, 1.0))
+		So(err, ShouldBeError)
+		_, err = NewSimple(NewConfigOption(ConfigPrefetch, -0.1))
+		So(err, ShouldBeError)
+		_, err = NewLRU(NewConfigOption(ConfigSize, 10), NewConfigOption(ConfigPrefetch, 1))
+		So(err, ShouldEqual, ConfigPrefetch.Error())
+	})
+}
+
+func Test_ExpirableLRUPrefetch(t *testing.T) {
+	// expirable LRUs leak a goro, see https://github.com/hashicorp/golang-lru/blob/main/expirable/expirable_lru.go#L53
+
+	Convey("When an expirable LRU prefetches, hot entries are looked up before their ItemTTL", t, func() {
+		fake := fakeResolver{ttl: TTLUnknown}
+
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigItemTTL, 100*time.Millisecond),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigPrefetch, 0.5),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		for i := range 4 {
+			time.Sleep(60 * time.Millisecond)
+			_, err = c.Fetch("dns.google.com")
+			So(err, ShouldBeNil)
+			So(waitFor(func() bool { return c.Stats().Lookups == uint64(i+2) }), ShouldBeTrue)
+		}
+
+		s := c.Stats()
+		So(s.Misses, ShouldEqual, 1)
+		So(s.Prefetches, ShouldEqual, 4)
+		So(fake.calls.Load(), ShouldEqual, 5)
+	})
+}
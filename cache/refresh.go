@@ -2,9 +2,14 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"math/rand/v2"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +26,10 @@ const (
 	// For values > 0, this is treated as a per-loop deadline
 	// to complete a Refresh.
 	ConfigRefreshTimeout = ConfigKey("RefreshTimeout")
+	// ConfigRefreshContext is a context.Context.
+	// When it is cancelled, a Refresh stops, waiting for any lookups it started, and returns its error.
+	// The caches pass one that Close cancels.
+	ConfigRefreshContext = ConfigKey("RefreshContext")
 
 	// RefreshOff is a RefreshType used when the cache should silently refuse
 	// to do Refreshes if requested.
@@ -34,18 +43,177 @@ const (
 	RefreshBatch = RefreshType("RefreshBatch")
 )
 
+// RefreshResult is the outcome of a Refresh pass.
+type RefreshResult struct {
+	// Completed is true if the pass visited every key, or false if it timed out, or failed.
+	Completed bool
+	// Keys is the number of keys the pass set out to visit.
+	Keys int
+	// Refreshed is the number of keys looked up successfully.
+	Refreshed int
+	// Skipped is the number of keys skipped, because they were evicted during the pass.
+	Skipped int
+	// Failures are the errors of the lookups that failed, by key.
+	Failures map[string]error
+	// Took is how long the pass took.
+	Took time.Duration
+}
+
+// ResultCache is an interface that caches which report the outcome of their Refresh passes implement.
+type ResultCache interface {
+	// RefreshResult is Refresh, but returns the outcome of the pass, or an error
+	// if the pass could not be made.
+	RefreshResult(timeout time.Duration) (RefreshResult, error)
+}
+
+// refreshTally accumulates the RefreshResult of a RefreshFunc, by wrapping the RefreshableCache and
+// the ResolverFunc passed to it. Failed lookups are logged as they happen.
+type refreshTally struct {
+	RefreshableCache
+
+	logger    *slog.Logger
+	keys      atomic.Int64
+	refreshed atomic.Int64
+	skipped   atomic.Int64
+	lock      sync.Mutex
+	failures  map[string]error
+}
+
+// Keys counts and returns the keys of the cache.
+func (t *refreshTally) Keys() []string {
+	k := t.RefreshableCache.Keys()
+	t.countKeys(len(k))
+	return k
+}
+
+// countKeys sets the number of keys the pass set out to visit. It is part of keyCounter.
+func (t *refreshTally) countKeys(n int) {
+	t.keys.Store(int64(n))
+}
+
+// Contains returns true if the address is in the cache, counting it as skipped if not.
+func (t *refreshTally) Contains(address string) bool {
+	ok := t.RefreshableCache.Contains(address)
+	if !ok {
+		t.skipped.Add(1)
+	}
+	return ok
+}
+
+// GetEntry returns the Entry for the address, if the wrapped cache is an EntryCache.
+func (t *refreshTally) GetEntry(address string) (Entry, bool) {
+	return getEntry(t.RefreshableCache, address)
+}
+
+// resolver returns the ResolverFunc, tallying and logging its lookups.
+func (t *refreshTally) resolver(f ResolverFunc) ResolverFunc {
+	return func(address string) ([]net.IP, error) {
+		ips, err := f(address)
+		if err != nil {
+			t.lock.Lock()
+			if t.failures == nil {
+				t.failures = make(map[string]error)
+			}
+			t.failures[address] = err
+			t.lock.Unlock()
+			t.logger.Warn("refresh lookup failed", "address", address, "error", err)
+		} else {
+			t.refreshed.Add(1)
+		}
+		return ips, err
+	}
+}
+
+// result returns the RefreshResult of the pass.
+func (t *refreshTally) result(took time.Duration, completed bool) RefreshResult {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// cloned, as timed-out batch lookups may still be finishing
+	return RefreshResult{
+		Completed: completed,
+		Keys:      int(t.keys.Load()),
+		Refreshed: int(t.refreshed.Load()),
+		Skipped:   int(t.skipped.Load()),
+		Failures:  maps.Clone(t.failures),
+		Took:      took,
+	}
+}
+
+// refreshError logs the error of a RefreshFunc, and returns it wrapped.
+// Passes cancelled by Close are not errors worth more than a debug.
+func refreshError(logger *slog.Logger, err error) error {
+	if errors.Is(err, context.Canceled) {
+		logger.Debug("refresh cancelled", "error", err)
+	} else {
+		logger.Error("refresh failed", "error", err)
+	}
+	return fmt.Errorf("error during RefreshFunc: %w", err)
+}
+
+// logResult logs a summary of the RefreshResult. Passes that timed out are warnings, passes with failures are
+// informational, and the rest are debug.
+func logResult(logger *slog.Logger, res RefreshResult) {
+	level := slog.LevelDebug
+	switch {
+	case !res.Completed:
+		level = slog.LevelWarn
+	case len(res.Failures) > 0:
+		level = slog.LevelInfo
+	}
+	logger.Log(context.Background(), level, "refresh pass finished",
+		"keys", res.Keys,
+		"refreshed", res.Refreshed,
+		"skipped", res.Skipped,
+		"failures", len(res.Failures),
+		"timedout", !res.Completed,
+		"took", res.Took,
+	)
+}
+
+// emptyCache is a RefreshableCache with nothing in it, to validate RefreshFunc options against.
+type emptyCache struct{}
+
+func (emptyCache) Keys() []string       { return nil }
+func (emptyCache) Contains(string) bool { return false }
+
+// validateRefresh dry-runs the RefreshFunc with the options against an empty cache,
+// returning any error, so that config errors are caught before the first Refresh.
+func validateRefresh(f RefreshFunc, options []ConfigOption) error {
+	if _, err := f(emptyCache{}, func(string) ([]net.IP, error) { return nil, nil }, options...); err != nil {
+		return fmt.Errorf("invalid refresh configuration: %w", err)
+	}
+	return nil
+}
+
+// refreshFuncFor returns the RefreshFunc for the RefreshType, and true, or nil and false if it is unknown.
+func refreshFuncFor(t RefreshType) (RefreshFunc, bool) {
+	switch t {
+	case RefreshOff:
+		return NoRefresh, true
+	case RefreshLinear:
+		return LinearRefresh, true
+	case RefreshBatch:
+		return BatchRefresh, true
+	case RefreshPopular:
+		return PopularRefresh, true
+	}
+	return nil, false
+}
+
 // NoRefresh is a noop RefreshFunc that always returns true, and never an error.
 func NoRefresh(cache RefreshableCache, resolver ResolverFunc, options ...ConfigOption) (bool, error) {
 	return true, nil
 }
 
 // LinearRefresh is the classic ordered, one-at-a-time RefreshFunc. By default, it will shuffle the keys,
-// sleep for 1s between each lookup, and continue until it is done (no timeout).
+// sleep for 1s between each lookup, and continue until it is done (no timeout), or its RefreshContext is cancelled.
 func LinearRefresh(cache RefreshableCache, resolver ResolverFunc, options ...ConfigOption) (bool, error) {
 	var (
-		refreshShuffle   bool          = true
-		refreshSleepTime time.Duration = 1 * time.Second
-		refreshTimeout   time.Duration // default off
+		refreshShuffle   bool            = true
+		refreshSleepTime time.Duration   = 1 * time.Second
+		refreshTimeout   time.Duration   // default off
+		refreshContext   context.Context = context.Background()
 	)
 	for _, o := range options {
 		switch o.Key {
@@ -67,11 +235,22 @@ func LinearRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Con
 			} else {
 				return false, o.Key.Error()
 			}
+		case ConfigRefreshContext:
+			if v, ok := o.Value.(context.Context); ok && v != nil {
+				refreshContext = v
+			} else {
+				return false, o.Key.Error()
+			}
 		default:
 			return false, ErrorConfigKeyUnsupported
 		}
 	}
 
+	if err := refreshContext.Err(); err != nil {
+		// cancelled before we started
+		return false, err
+	}
+
 	// Get the keys
 	addresses := cache.Keys()
 
@@ -93,10 +272,10 @@ func LinearRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Con
 
 	if refreshTimeout == 0 {
 		// No deadline
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(refreshContext)
 	} else {
 		// Deadline
-		ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(refreshTimeout))
+		ctx, cancel = context.WithDeadline(refreshContext, time.Now().Add(refreshTimeout))
 	}
 	defer cancel() // because yes
 
@@ -125,20 +304,22 @@ func LinearRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Con
 				i++
 			}
 		case <-ctx.Done():
-			// took too long, deadline exceeded.
-			return false, nil
+			// cancelled, or took too long, deadline exceeded.
+			return false, refreshContext.Err()
 		}
 	}
 	return true, nil
 }
 
 // BatchRefresh uses workers to do RefreshBatchSize lookups at a time. By default, it will shuffle the keys,
-// sleep 1s between each batch, and run until it is done (no timeout).
+// sleep 1s between each batch, and run until it is done (no timeout), or its RefreshContext is cancelled.
+// When cancelled, it waits for the workers to finish. When timed out, it does not.
 func BatchRefresh(cache RefreshableCache, resolver ResolverFunc, options ...ConfigOption) (bool, error) {
 	var (
-		refreshShuffle   bool          = true
-		refreshSleepTime time.Duration = 1 * time.Second
-		refreshTimeout   time.Duration // default off
+		refreshShuffle   bool            = true
+		refreshSleepTime time.Duration   = 1 * time.Second
+		refreshTimeout   time.Duration   // default off
+		refreshContext   context.Context = context.Background()
 		batchSize        int
 	)
 	if v, ok := ConfigRefreshBatchSize.IsIn(options); !ok {
@@ -167,6 +348,12 @@ func BatchRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Conf
 			} else {
 				return false, o.Key.Error()
 			}
+		case ConfigRefreshContext:
+			if v, ok := o.Value.(context.Context); ok && v != nil {
+				refreshContext = v
+			} else {
+				return false, o.Key.Error()
+			}
 		case ConfigRefreshBatchSize:
 			// we already applied this.
 		default:
@@ -174,6 +361,11 @@ func BatchRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Conf
 		}
 	}
 
+	if err := refreshContext.Err(); err != nil {
+		// cancelled before we started
+		return false, err
+	}
+
 	// Get the keys
 	addresses := cache.Keys()
 
@@ -195,10 +387,10 @@ func BatchRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Conf
 
 	if refreshTimeout == 0 {
 		// No deadline
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(refreshContext)
 	} else {
 		// Deadline
-		ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(refreshTimeout))
+		ctx, cancel = context.WithDeadline(refreshContext, time.Now().Add(refreshTimeout))
 	}
 	defer cancel() // because yes
 
@@ -219,7 +411,8 @@ func BatchRefresh(cache RefreshableCache, resolver ResolverFunc, options ...Conf
 		}
 	}
 	if total >= len(addresses) {
-		//sub-batch cache size, we done!
+		//sub-batch cache size, we done once they are!
+		wg.Wait()
 		return true, nil
 	}
 
@@ -249,6 +442,11 @@ DONE:
 				}
 			}
 		case <-ctx.Done():
+			if err := refreshContext.Err(); err != nil {
+				// cancelled, so let the workers finish
+				wg.Wait()
+				return false, err
+			}
 			// took too long, deadline exceeded.
 			return false, nil
 		}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"math/rand/v2"
 	"net"
@@ -259,3 +260,68 @@ func Benchmark_Shuffle1000(b *testing.B) {
 		})
 	}
 }
+
+func Test_RefreshResult(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Refresh pass is made, its RefreshResult reflects it", t, func() {
+		fake := fakeResolver{ttl: TTLUnknown}
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 5),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshType, RefreshBatch),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		c.Fetch("one.one.one.one")
+		res, err := c.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Completed, ShouldBeTrue)
+		So(res.Keys, ShouldEqual, 2)
+		So(res.Refreshed, ShouldEqual, 2)
+		So(res.Failures, ShouldBeEmpty)
+		So(res.Took, ShouldBeGreaterThan, 0)
+
+		fake.fail.Store(true)
+		res, err = c.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Refreshed, ShouldEqual, 0)
+		So(res.Failures, ShouldHaveLength, 2)
+		So(res.Failures["dns.google.com"], ShouldBeError, "boom")
+	})
+
+	Convey("When a RefreshFunc fails, the error is returned, and Refresh does not panic", t, func() {
+		c, err := NewSimple()
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.refresh = func(RefreshableCache, ResolverFunc, ...ConfigOption) (bool, error) {
+			return false, ErrorConfigKeyUnsupported
+		}
+		_, err = c.RefreshResult(0)
+		So(errors.Is(err, ErrorConfigKeyUnsupported), ShouldBeTrue)
+		So(func() { c.Refresh(0) }, ShouldNotPanic)
+		So(c.Stats().RefreshesTimedOut, ShouldEqual, 0)
+	})
+}
+
+func Test_RefreshConfigErrors(t *testing.T) {
+	Convey("When the refresh configuration is invalid, construction fails", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigRefreshType, RefreshType("RefreshSometimes")))
+		So(err, ShouldBeError)
+
+		_, err = NewLRU(
+			NewConfigOption(ConfigSize, 5),
+			NewConfigOption(ConfigRefreshType, RefreshBatch),
+			NewConfigOption(ConfigRefreshBatchSize, 0),
+		)
+		So(err, ShouldBeError)
+
+		So(validateRefresh(BatchRefresh, nil), ShouldBeError)
+		So(validateRefresh(LinearRefresh, []ConfigOption{NewConfigOption(ConfigRefreshTimeout, 0)}), ShouldBeError)
+		So(validateRefresh(LinearRefresh, nil), ShouldBeNil)
+	})
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// ConfigServeStale is a time.Duration.
+	// Values > 0 enable serve-stale (RFC 8767): expired entries are retained for this long past
+	// their expiry, and are returned by Fetch if the live lookup fails.
+	// Expiry comes from record TTLs (see RecordTTL) and, for LRUs, ItemTTL.
+	// 0 disables serve-stale.
+	ConfigServeStale = ConfigKey("ServeStale")
+)
+
+// StaleCache is an interface that caches which can serve stale entries implement.
+type StaleCache interface {
+	// FetchStale is FetchContext, but also returns true if the collection returned
+	// is a stale entry, served because the live lookup failed.
+	FetchStale(ctx context.Context, address string) ([]net.IP, bool, error)
+}
+
+// servable returns true if the entry is expired, but within the stale window at now.
+func (e *entry) servable(window time.Duration, now time.Time) bool {
+	return window > 0 && e.expired(now) && now.Before(e.expires.Add(window))
+}
+
+// defunct returns true if the entry is expired, and beyond the stale window at now.
+func (e *entry) defunct(window time.Duration, now time.Time) bool {
+	return e.expired(now) && !now.Before(e.expires.Add(window))
+}
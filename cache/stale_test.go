@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SimpleServeStale(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple serves stale, expired entries are served, flagged, if the lookup fails", t, func() {
+		fake := fakeResolver{ttl: 10 * time.Millisecond}
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigServeStale, 50*time.Millisecond),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		ips, stale, err := c.FetchStale(context.Background(), "dns.google.com")
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeFalse)
+		So(ipsTov4(ips...), ShouldResemble, []string{"8.8.8.8"})
+
+		fake.fail.Store(true)
+		time.Sleep(20 * time.Millisecond)
+
+		ips, stale, err = c.FetchStale(context.Background(), "dns.google.com")
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeTrue)
+		So(ipsTov4(ips...), ShouldResemble, []string{"8.8.8.8"})
+
+		Convey("but not past the stale window", func() {
+			time.Sleep(50 * time.Millisecond)
+			ips, stale, err = c.FetchStale(context.Background(), "dns.google.com")
+			So(err, ShouldBeError)
+			So(stale, ShouldBeFalse)
+			So(ips, ShouldBeNil)
+
+			c.Refresh(0)
+			So(c.Len(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("When a Simple does not serve stale, expired entries are not served", t, func() {
+		fake := fakeResolver{ttl: time.Millisecond}
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRecordTTL, true),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		fake.fail.Store(true)
+		time.Sleep(5 * time.Millisecond)
+
+		_, err = c.Fetch("dns.google.com")
+		So(err, ShouldBeError)
+	})
+}
+
+func Test_LRUServeStale(t *testing.T) {
+
+	// Cannot leaktest expirable.LRU. https://github.com/hashicorp/golang-lru/blob/1ecdc13547b564bf736db9161ed89f1864010108/expirable/expirable_lru.go#L53
+	Convey("When an expirable LRU serves stale, items past their ItemTTL are served, flagged, if the lookup fails", t, func() {
+		fake := fakeResolver{ttl: TTLUnknown}
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigItemTTL, 10*time.Millisecond),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigServeStale, time.Minute),
+			NewConfigOption(ConfigNegativeTTL, time.Minute),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		fake.fail.Store(true)
+		time.Sleep(20 * time.Millisecond)
+
+		ips, stale, err := c.FetchStale(context.Background(), "dns.google.com")
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeTrue)
+		So(ipsTov4(ips...), ShouldResemble, []string{"8.8.8.8"})
+
+		// now negatively cached, still stale
+		ips, stale, err = c.FetchStale(context.Background(), "dns.google.com")
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeTrue)
+		So(ipsTov4(ips...), ShouldResemble, []string{"8.8.8.8"})
+
+		So(c.config(NewConfigOption(ConfigServeStale, "1m")), ShouldBeError)
+	})
+}
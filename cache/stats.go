@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ConfigRecorder is a Recorder.
+	// The Recorder is told the outcome of each live lookup and Refresh pass, as they finish,
+	// e.g. to maintain latency histograms. See the metrics package.
+	ConfigRecorder = ConfigKey("Recorder")
+)
+
+// Recorder is told the outcome of live lookups and Refresh passes, as they finish,
+// in addition to their being counted in the Stats.
+// Implementations must be goro-safe, and should return quickly.
+type Recorder interface {
+	// RecordLookup is called after each live lookup, with how long it took and its error, if any.
+	RecordLookup(took time.Duration, err error)
+	// RecordRefresh is called after each Refresh pass, with how long it took and whether it completed.
+	RecordRefresh(took time.Duration, completed bool)
+}
+
+// StatsCache is an interface that caches which keep Stats implement.
+type StatsCache interface {
+	// Stats returns a snapshot of the statistics of the cache.
+	Stats() Stats
+}
+
+// Stats is a point-in-time snapshot of the statistics of a cache.
+type Stats struct {
+	// Hits is the number of Fetches served fresh from the cache.
+	Hits uint64
+	// StaleHits is the number of Fetches served a stale entry, because the lookup failed.
+	StaleHits uint64
+	// NegativeHits is the number of Fetches served a negatively-cached failure.
+	NegativeHits uint64
+	// Misses is the number of Fetches that required a lookup.
+	Misses uint64
+	// Lookups is the number of live lookups, including those made by Refresh.
+	Lookups uint64
+	// LookupErrors is the number of live lookups that failed.
+	LookupErrors uint64
+	// Evictions is the number of entries removed by the cache itself, due to size or age.
+	Evictions uint64
+	// Prefetches is the number of background lookups started by Fetch hits on entries nearing expiry.
+	Prefetches uint64
+	// RefreshesCompleted is the number of Refresh passes that completed.
+	RefreshesCompleted uint64
+	// RefreshesTimedOut is the number of Refresh passes that ran out of time.
+	RefreshesTimedOut uint64
+	// LookupLatency is the average duration of the live lookups.
+	LookupLatency time.Duration
+}
+
+// counters is the goro-safe accumulator of Stats shared by the caches.
+// The zero value is ready to use.
+type counters struct {
+	hits               atomic.Uint64
+	staleHits          atomic.Uint64
+	negativeHits       atomic.Uint64
+	misses             atomic.Uint64
+	lookups            atomic.Uint64
+	lookupErrors       atomic.Uint64
+	evictions          atomic.Uint64
+	prefetches         atomic.Uint64
+	refreshesCompleted atomic.Uint64
+	refreshesTimedOut  atomic.Uint64
+	lookupNanos        atomic.Int64
+	recorder           Recorder
+}
+
+// config applies the ConfigOption if it is a counters option, returning true if it was.
+func (c *counters) config(opt ConfigOption) (bool, error) {
+	switch opt.Key {
+	case ConfigRecorder:
+		if v, ok := opt.Value.(Recorder); ok {
+			c.recorder = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// lookup records a live lookup that took the specified Duration.
+func (c *counters) lookup(took time.Duration, err error) {
+	c.lookups.Add(1)
+	c.lookupNanos.Add(int64(took))
+	if err != nil {
+		c.lookupErrors.Add(1)
+	}
+	if c.recorder != nil {
+		c.recorder.RecordLookup(took, err)
+	}
+}
+
+// refresh records a Refresh pass that took the specified Duration, and whether it completed.
+func (c *counters) refresh(took time.Duration, completed bool) {
+	if completed {
+		c.refreshesCompleted.Add(1)
+	} else {
+		c.refreshesTimedOut.Add(1)
+	}
+	if c.recorder != nil {
+		c.recorder.RecordRefresh(took, completed)
+	}
+}
+
+// snapshot returns the current Stats.
+func (c *counters) snapshot() Stats {
+	s := Stats{
+		Hits:               c.hits.Load(),
+		StaleHits:          c.staleHits.Load(),
+		NegativeHits:       c.negativeHits.Load(),
+		Misses:             c.misses.Load(),
+		Lookups:            c.lookups.Load(),
+		LookupErrors:       c.lookupErrors.Load(),
+		Evictions:          c.evictions.Load(),
+		Prefetches:         c.prefetches.Load(),
+		RefreshesCompleted: c.refreshesCompleted.Load(),
+		RefreshesTimedOut:  c.refreshesTimedOut.Load(),
+	}
+	if s.Lookups > 0 {
+		s.LookupLatency = time.Duration(c.lookupNanos.Load() / int64(s.Lookups))
+	}
+	return s
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SimpleStats(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple is used, its Stats reflect that use", t, func() {
+		fake := fakeResolver{ttl: TTLUnknown}
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigNegativeTTL, time.Minute),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		So(c.Stats(), ShouldResemble, Stats{})
+
+		c.Fetch("dns.google.com") // miss
+		c.Fetch("dns.google.com") // hit
+		fake.fail.Store(true)
+		c.Fetch("invalid.viki.io") // miss, error
+		c.Fetch("invalid.viki.io") // negative hit
+		c.Refresh(0)               // 1 lookup, 1 error
+
+		s := c.Stats()
+		So(s.Hits, ShouldEqual, 1)
+		So(s.Misses, ShouldEqual, 2)
+		So(s.NegativeHits, ShouldEqual, 1)
+		So(s.StaleHits, ShouldEqual, 0)
+		So(s.Lookups, ShouldEqual, 3)
+		So(s.LookupErrors, ShouldEqual, 2)
+		So(s.RefreshesCompleted, ShouldEqual, 1)
+		So(s.RefreshesTimedOut, ShouldEqual, 0)
+		So(s.LookupLatency, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("When a Simple Refresh times out, its Stats say so", t, func() {
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverFunc(func(string) ([]net.IP, error) {
+				return nil, errors.New("boom")
+			})),
+			NewConfigOption(ConfigRefreshSleepTime, time.Minute),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Add("dns.google.com", []net.IP{})
+		c.Add("www.google.com", []net.IP{})
+		c.Refresh(time.Millisecond)
+
+		So(c.Stats().RefreshesTimedOut, ShouldEqual, 1)
+	})
+}
+
+func Test_LRUStatsEvictions(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a 2Q LRU is full, its evictions are counted, but Removes and Purges are not", t, func() {
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 5),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for i := range 8 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{})
+		}
+		So(c.Len(), ShouldEqual, 5)
+		So(c.Stats().Evictions, ShouldEqual, 3)
+
+		c.Remove("7.localhost")
+		c.Purge()
+		So(c.Stats().Evictions, ShouldEqual, 3)
+	})
+}
+
+func Test_ExpirableLRUStatsEvictions(t *testing.T) {
+
+	// Cannot leaktest expirable.LRU. https://github.com/hashicorp/golang-lru/blob/1ecdc13547b564bf736db9161ed89f1864010108/expirable/expirable_lru.go#L53
+	Convey("When an expirable LRU is full, or items expire, its evictions are counted, but Removes and Purges are not", t, func() {
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 5),
+			NewConfigOption(ConfigItemTTL, 20*time.Millisecond),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for i := range 8 {
+			c.Add(fmt.Sprintf("%d.localhost", i), []net.IP{})
+		}
+		So(c.Stats().Evictions, ShouldEqual, 3)
+
+		c.Remove("7.localhost")
+		So(c.Stats().Evictions, ShouldEqual, 3)
+
+		// expired, and gone once the background cleanup gets around to it
+		deadline := time.Now().Add(2 * time.Second)
+		for c.Stats().Evictions < 7 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(c.Len(), ShouldEqual, 0)
+		So(c.Stats().Evictions, ShouldEqual, 7)
+
+		c.Purge()
+	})
+}
+
+// testRecorder is a Recorder that counts what it is told.
+type testRecorder struct {
+	lookups, lookupErrors, refreshes atomic.Int32
+}
+
+func (t *testRecorder) RecordLookup(took time.Duration, err error) {
+	t.lookups.Add(1)
+	if err != nil {
+		t.lookupErrors.Add(1)
+	}
+}
+
+func (t *testRecorder) RecordRefresh(took time.Duration, completed bool) {
+	t.refreshes.Add(1)
+}
+
+func Test_Recorder(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a cache has a Recorder, it is told of each lookup and Refresh", t, func() {
+		var (
+			fake = fakeResolver{ttl: TTLUnknown}
+			rec  testRecorder
+		)
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 5),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+			NewConfigOption(ConfigRecorder, &rec),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		fake.fail.Store(true)
+		c.Fetch("invalid.viki.io")
+		c.Refresh(0)
+
+		So(rec.lookups.Load(), ShouldEqual, 3)
+		So(rec.lookupErrors.Load(), ShouldEqual, 2)
+		So(rec.refreshes.Load(), ShouldEqual, 1)
+	})
+
+	Convey("When a Recorder is not a Recorder, an error is returned", t, func() {
+		_, err := NewSimple(NewConfigOption(ConfigRecorder, "recorder"))
+		So(err, ShouldEqual, ConfigRecorder.Error())
+	})
+}
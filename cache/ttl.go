@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// ConfigRecordTTL is a bool.
+	// True will expire each entry according to the TTL returned by a ResolverTTLFunc,
+	// clamped by RecordTTLMin and RecordTTLMax. Expired entries are treated as misses by Fetch,
+	// and are the only entries with a TTL visited by Refresh.
+	// Entries with a TTLUnknown, and those Added manually, never expire, and are visited by every
+	// Refresh, as if RecordTTL were false.
+	// Only a ResolverTTLFunc can know TTLs: DefaultResolverContext, and any ResolverFunc or
+	// ResolverContextFunc, always return TTLUnknown, so RecordTTL has no effect with them. See
+	// the upstream package for a ResolverTTLFunc.
+	ConfigRecordTTL = ConfigKey("RecordTTL")
+	// ConfigRecordTTLMin is a time.Duration.
+	// Record TTLs below this value are raised to it.
+	ConfigRecordTTLMin = ConfigKey("RecordTTLMin")
+	// ConfigRecordTTLMax is a time.Duration.
+	// Record TTLs above this value are lowered to it. 0 is unlimited.
+	ConfigRecordTTLMax = ConfigKey("RecordTTLMax")
+
+	// TTLUnknown is returned by a ResolverTTLFunc when the resolver cannot determine the TTL.
+	TTLUnknown = time.Duration(-1)
+)
+
+// ResolverTTLFunc is a ResolverContextFunc that also returns the TTL of the answer, which should
+// be the lowest TTL of the records returned, or TTLUnknown.
+type ResolverTTLFunc func(ctx context.Context, address string) ([]net.IP, time.Duration, error)
+
+// ExpiringCache is an interface that caches honoring record TTLs implement, so that
+// callers can schedule Refreshes around entry expiry.
+type ExpiringCache interface {
+	// Expiring returns true if entries expire according to their record TTLs, and are
+	// refreshed as they do.
+	Expiring() bool
+	// NextExpiry returns the earliest expiry of any entry that is after the specified Time,
+	// or the zero Time if there is none.
+	NextExpiry(after time.Time) time.Time
+}
+
+// ttlConfig is the record TTL configuration shared by the caches.
+type ttlConfig struct {
+	enabled bool
+	min     time.Duration
+	max     time.Duration
+}
+
+// expiry returns when an answer with the specified TTL, received at now, should expire,
+// or the zero Time if it should not.
+func (t *ttlConfig) expiry(ttl time.Duration, now time.Time) time.Time {
+	if !t.enabled || ttl == TTLUnknown {
+		return time.Time{}
+	}
+	if ttl < t.min {
+		ttl = t.min
+	}
+	if t.max > 0 && ttl > t.max {
+		ttl = t.max
+	}
+	return now.Add(ttl)
+}
+
+// config is an internal validator and applier for the ConfigRecordTTL* ConfigOptions.
+// The bool returned is false if the option is not a ConfigRecordTTL* option.
+func (t *ttlConfig) config(opt ConfigOption) (bool, error) {
+	switch opt.Key {
+	case ConfigRecordTTL:
+		if v, ok := opt.Value.(bool); ok {
+			t.enabled = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigRecordTTLMin:
+		if v, ok := opt.Value.(time.Duration); ok {
+			t.min = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	case ConfigRecordTTLMax:
+		if v, ok := opt.Value.(time.Duration); ok {
+			t.max = v
+		} else {
+			return true, opt.Key.Error()
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// dueCache is a RefreshableCache whose Keys are only those of entries due a Refresh.
+type dueCache struct {
+	RefreshableCache
+	due func(address string, now time.Time) bool
+}
+
+// Keys returns the keys of the entries due a Refresh.
+func (e *dueCache) Keys() []string {
+	var (
+		now  = time.Now()
+		keys = e.RefreshableCache.Keys()
+		out  = keys[:0]
+	)
+	for _, k := range keys {
+		if e.due(k, now) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// GetEntry returns the Entry for the address, if the wrapped cache is an EntryCache.
+func (e *dueCache) GetEntry(address string) (Entry, bool) {
+	return getEntry(e.RefreshableCache, address)
+}
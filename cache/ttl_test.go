@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_TTLConfigExpiry(t *testing.T) {
+	Convey("When a ttlConfig computes expiries, they are clamped as expected", t, func() {
+		now := time.Now()
+		tc := ttlConfig{
+			enabled: true,
+			min:     10 * time.Second,
+			max:     time.Minute,
+		}
+
+		So(tc.expiry(TTLUnknown, now), ShouldBeZeroValue)
+		So(tc.expiry(0, now), ShouldEqual, now.Add(10*time.Second))
+		So(tc.expiry(30*time.Second, now), ShouldEqual, now.Add(30*time.Second))
+		So(tc.expiry(time.Hour, now), ShouldEqual, now.Add(time.Minute))
+
+		tc.max = 0
+		So(tc.expiry(time.Hour, now), ShouldEqual, now.Add(time.Hour))
+
+		tc.enabled = false
+		So(tc.expiry(time.Hour, now), ShouldBeZeroValue)
+	})
+}
+
+func Test_SimpleRecordTTL(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Simple honors record TTLs, entries expire on their own TTL", t, func() {
+		fake := fakeResolver{ttl: 20 * time.Millisecond}
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigRecordTTLMax, time.Minute),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		So(c.NextExpiry(time.Now()), ShouldBeZeroValue)
+
+		c.Fetch("dns.google.com")
+		c.Fetch("dns.google.com")
+		So(fake.calls.Load(), ShouldEqual, 1)
+		So(c.NextExpiry(time.Now()), ShouldHappenWithin, 20*time.Millisecond, time.Now().Add(20*time.Millisecond))
+
+		time.Sleep(30 * time.Millisecond)
+		c.Fetch("dns.google.com")
+		So(fake.calls.Load(), ShouldEqual, 2)
+
+		Convey("and Refresh only visits expired entries, and those without a TTL", func() {
+			c.Add("www.google.com", []net.IP{}) // has no TTL
+			c.Refresh(0)
+			So(fake.calls.Load(), ShouldEqual, 3)
+			ips, _ := c.Get("www.google.com")
+			So(ips, ShouldHaveLength, 1)
+
+			c.Refresh(0)
+			So(fake.calls.Load(), ShouldEqual, 3) // both have TTLs now
+
+			time.Sleep(30 * time.Millisecond)
+			c.Refresh(0)
+			So(fake.calls.Load(), ShouldEqual, 5)
+		})
+	})
+
+	Convey("When a Simple honors record TTLs, but its resolver cannot know them, every Refresh visits every entry", t, func() {
+		var calls atomic.Int32
+		resolver := DefaultResolverContext
+		defer func() { DefaultResolverContext = resolver }()
+		DefaultResolverContext = func(ctx context.Context, address string) ([]net.IP, error) {
+			calls.Add(1)
+			return []net.IP{net.ParseIP("8.8.8.8")}, nil
+		}
+
+		c, err := NewSimple(
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		c.Fetch("dns.google.com")
+		So(calls.Load(), ShouldEqual, 1)
+		So(c.NextExpiry(time.Now()), ShouldBeZeroValue)
+		e, _ := c.GetEntry("dns.google.com")
+		So(e.Expires, ShouldBeZeroValue)
+
+		for range 2 {
+			c.Refresh(0)
+		}
+		So(calls.Load(), ShouldEqual, 3)
+	})
+
+	Convey("When a Simple has record TTLs disabled, TTLs are ignored", t, func() {
+		fake := fakeResolver{ttl: time.Nanosecond}
+		c, err := NewSimple(
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		time.Sleep(time.Millisecond)
+		c.Fetch("dns.google.com")
+		So(fake.calls.Load(), ShouldEqual, 1)
+		So(c.NextExpiry(time.Now()), ShouldBeZeroValue)
+	})
+}
+
+func Test_LRURecordTTL(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When an LRU honors record TTLs, entries expire on their own TTL, clamped by the minimum", t, func() {
+		fake := fakeResolver{} // a TTL of 0, clamped to the minimum
+		c, err := NewLRU(
+			NewConfigOption(ConfigSize, 10),
+			NewConfigOption(ConfigResolver, ResolverTTLFunc(fake.resolve)),
+			NewConfigOption(ConfigRecordTTL, true),
+			NewConfigOption(ConfigRecordTTLMin, 20*time.Millisecond),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		c.Fetch("dns.google.com")
+		c.Fetch("dns.google.com")
+		So(fake.calls.Load(), ShouldEqual, 1)
+		So(c.NextExpiry(time.Now()), ShouldNotBeZeroValue)
+
+		time.Sleep(30 * time.Millisecond)
+		c.Fetch("dns.google.com")
+		So(fake.calls.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When an LRU is configured with RecordTTL options of the wrong type, it generates an appropriate error", t, func() {
+		c, err := NewLRU(NewConfigOption(ConfigSize, 10))
+		So(err, ShouldBeNil)
+		So(c.config(NewConfigOption(ConfigRecordTTL, "yes")), ShouldBeError)
+		So(c.config(NewConfigOption(ConfigRecordTTLMin, 5)), ShouldBeError)
+		So(c.config(NewConfigOption(ConfigRecordTTLMax, false)), ShouldBeError)
+	})
+}
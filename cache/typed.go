@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	// DefaultSRVResolver resolves the SRV records of a name, e.g. "_http._tcp.example.com".
+	DefaultSRVResolver ValueResolverFunc[[]*net.SRV] = func(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		return srvs, TTLUnknown, err
+	}
+
+	// DefaultTXTResolver resolves the TXT records of a name.
+	DefaultTXTResolver ValueResolverFunc[[]string] = func(ctx context.Context, name string) ([]string, time.Duration, error) {
+		txts, err := net.DefaultResolver.LookupTXT(ctx, name)
+		return txts, TTLUnknown, err
+	}
+
+	// DefaultMXResolver resolves the MX records of a name, sorted by preference.
+	DefaultMXResolver ValueResolverFunc[[]*net.MX] = func(ctx context.Context, name string) ([]*net.MX, time.Duration, error) {
+		mxs, err := net.DefaultResolver.LookupMX(ctx, name)
+		return mxs, TTLUnknown, err
+	}
+
+	// DefaultCNAMEResolver resolves the canonical name of a name.
+	DefaultCNAMEResolver ValueResolverFunc[string] = func(ctx context.Context, name string) (string, time.Duration, error) {
+		cname, err := net.DefaultResolver.LookupCNAME(ctx, name)
+		return cname, TTLUnknown, err
+	}
+
+	// DefaultAddrResolver resolves the names of an address, via its PTR records.
+	DefaultAddrResolver ValueResolverFunc[[]string] = func(ctx context.Context, addr string) ([]string, time.Duration, error) {
+		names, err := net.DefaultResolver.LookupAddr(ctx, addr)
+		return names, TTLUnknown, err
+	}
+)
+
+// ValueResolverFunc is a ResolverTTLFunc for values other than IPs, e.g. SRV or TXT records.
+// The TTL should be the lowest TTL of the records returned, or TTLUnknown.
+type ValueResolverFunc[V any] func(ctx context.Context, key string) (V, time.Duration, error)
+
+// valueResolver resolves the values of a Cache, which the entries of its store hold instead of IPs.
+type valueResolver func(ctx context.Context, key string) (any, time.Duration, error)
+
+// valueStore is what Cache needs of the Simple or LRU that stores its entries.
+type valueStore interface {
+	RefreshableCache
+	RefreshResult(timeout time.Duration) (RefreshResult, error)
+	Remove(address string)
+	Purge()
+	Len() int
+	Stats() Stats
+	Close() error
+
+	fetch(ctx context.Context, address string) (entry, bool, error)
+	lookupEntry(ctx context.Context, address string) (entry, error)
+	get(address string) (entry, bool)
+	addValue(address string, value any)
+}
+
+// Cache is a cache of values of any type, e.g. the SRV records of service discovery, resolved by a
+// ValueResolverFunc. Its entries are stored by a Simple, or an LRU if ConfigSize is specified, so the
+// ConfigOptions of those, and their Refresh machinery, apply. Entries are keyed by name.
+// Observers configured via ConfigObserver are told of the entries, but their IPs are always nil.
+type Cache[V any] struct {
+	store    valueStore
+	resolver ValueResolverFunc[V]
+}
+
+// NewCache instantiates a Cache of the values resolved by the resolver.
+// Valid ConfigOptions are those of NewLRU if Size is specified, otherwise those of NewSimple, but
+// not Resolver.
+// Required are: none.
+// Defaults are those of NewLRU, or NewSimple.
+func NewCache[V any](resolver ValueResolverFunc[V], options ...ConfigOption) (*Cache[V], error) {
+	if resolver == nil {
+		return nil, configError(loggerIn(options), errors.New("a ValueResolverFunc is required"))
+	}
+	if _, ok := ConfigResolver.IsIn(options); ok {
+		return nil, configError(loggerIn(options), fmt.Errorf("%s: %w", ConfigResolver, ErrorConfigKeyUnsupported))
+	}
+
+	c := &Cache[V]{
+		resolver: resolver,
+	}
+	if _, ok := ConfigSize.IsIn(options); ok {
+		l, err := NewLRU(options...)
+		if err != nil {
+			return nil, err
+		}
+		l.values = c.resolve
+		c.store = l
+	} else {
+		s, err := NewSimple(options...)
+		if err != nil {
+			return nil, err
+		}
+		s.values = c.resolve
+		c.store = s
+	}
+	return c, nil
+}
+
+// resolve is the valueResolver of the store.
+func (c *Cache[V]) resolve(ctx context.Context, key string) (any, time.Duration, error) {
+	return c.resolver(ctx, key)
+}
+
+// value returns the value of the entry.
+func value[V any](e entry) V {
+	v, _ := e.value.(V) // nil, for the zero value of an interface V
+	return v
+}
+
+// Fetch retrieves a value from the cache,
+// or performs a live lookup and adds it to the cache.
+func (c *Cache[V]) Fetch(key string) (V, error) {
+	return c.FetchContext(context.Background(), key)
+}
+
+// FetchContext retrieves a value from the cache,
+// or performs a live lookup, honoring the Context, and adds it to the cache.
+func (c *Cache[V]) FetchContext(ctx context.Context, key string) (V, error) {
+	v, _, err := c.FetchStale(ctx, key)
+	return v, err
+}
+
+// FetchStale is FetchContext, but also returns true if the value returned
+// is a stale entry, served because the live lookup failed.
+func (c *Cache[V]) FetchStale(ctx context.Context, key string) (V, bool, error) {
+	e, stale, err := c.store.fetch(ctx, key)
+	return value[V](e), stale, err
+}
+
+// Lookup returns a value from a live lookup, and updates the cache.
+// Most callers should use one of the Fetch functions.
+func (c *Cache[V]) Lookup(key string) (V, error) {
+	return c.LookupContext(context.Background(), key)
+}
+
+// LookupContext returns a value from a live lookup, honoring the Context,
+// and updates the cache.
+// Concurrent lookups of the same key are coalesced into a single resolver call.
+func (c *Cache[V]) LookupContext(ctx context.Context, key string) (V, error) {
+	e, err := c.store.lookupEntry(ctx, key)
+	return value[V](e), err
+}
+
+// Get will return a value from the cache, also bool if
+// a value was retrieved.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	e, ok := c.store.get(key)
+	return value[V](e), ok
+}
+
+// Add will upsert a value into the cache.
+func (c *Cache[V]) Add(key string, value V) {
+	c.store.addValue(key, value)
+}
+
+// Remove will remove a value, or negatively-cached failure, from the cache, if it exists.
+func (c *Cache[V]) Remove(key string) {
+	c.store.Remove(key)
+}
+
+// Purge removes all entries from the cache.
+func (c *Cache[V]) Purge() {
+	c.store.Purge()
+}
+
+// Contains returns true if a value is in the cache.
+func (c *Cache[V]) Contains(key string) bool {
+	return c.store.Contains(key)
+}
+
+// Keys returns a slice of the cache keys
+func (c *Cache[V]) Keys() []string {
+	return c.store.Keys()
+}
+
+// Len will return the number of items in the cache.
+func (c *Cache[V]) Len() int {
+	return c.store.Len()
+}
+
+// Stats returns a snapshot of the statistics of the cache.
+func (c *Cache[V]) Stats() Stats {
+	return c.store.Stats()
+}
+
+// Refresh will crawl the cache and update their entries, per the RefreshType of the store.
+// A timeout of 0 must mean no timeout.
+// Errors are logged, rather than returned. See RefreshResult.
+func (c *Cache[V]) Refresh(timeout time.Duration) {
+	c.store.RefreshResult(timeout)
+}
+
+// RefreshResult is Refresh, but returns the RefreshResult of the pass, or an error
+// if the RefreshFunc failed, or was cancelled by Close. ErrorClosed is returned if the cache is closed.
+func (c *Cache[V]) RefreshResult(timeout time.Duration) (RefreshResult, error) {
+	return c.store.RefreshResult(timeout)
+}
+
+// Close cancels any Refresh, and prefetches, in progress, and waits for them to finish.
+// Refreshes are refused thereafter.
+func (c *Cache[V]) Close() error {
+	return c.store.Close()
+}
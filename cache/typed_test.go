@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// srvResolver is a ValueResolverFunc of SRV records that counts its lookups, and answers a
+// target of the name, on the port, or fails for "missing.test".
+type srvResolver struct {
+	port  atomic.Int32
+	calls atomic.Int32
+}
+
+func (s *srvResolver) resolve(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	s.calls.Add(1)
+	if name == "missing.test" {
+		return nil, TTLUnknown, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return []*net.SRV{{Target: "target." + name, Port: uint16(s.port.Load())}}, time.Minute, nil
+}
+
+// valueRemover is an Observer that removes every entry added to its Cache, as if it were evicted at once.
+type valueRemover struct {
+	NoopObserver
+	cache *Cache[[]*net.SRV]
+}
+
+func (v *valueRemover) EntryAdded(address string, _ []net.IP) {
+	v.cache.Remove(address)
+}
+
+func Test_CacheFetch(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When values are fetched, they are looked up once, and cached", t, func() {
+		var r srvResolver
+		r.port.Store(80)
+		c, err := NewCache(r.resolve)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		srvs, err := c.Fetch("_http._tcp.a.test")
+		So(err, ShouldBeNil)
+		So(srvs, ShouldHaveLength, 1)
+		So(srvs[0].Target, ShouldEqual, "target._http._tcp.a.test")
+		So(srvs[0].Port, ShouldEqual, 80)
+
+		r.port.Store(8080)
+		srvs, err = c.Fetch("_http._tcp.a.test")
+		So(err, ShouldBeNil)
+		So(srvs[0].Port, ShouldEqual, 80)
+		So(r.calls.Load(), ShouldEqual, 1)
+		So(c.Stats().Hits, ShouldEqual, 1)
+
+		srvs, err = c.Lookup("_http._tcp.a.test")
+		So(err, ShouldBeNil)
+		So(srvs[0].Port, ShouldEqual, 8080)
+
+		srvs, ok := c.Get("_http._tcp.a.test")
+		So(ok, ShouldBeTrue)
+		So(srvs[0].Port, ShouldEqual, 8080)
+		So(c.Keys(), ShouldResemble, []string{"_http._tcp.a.test"})
+		So(c.Contains("_http._tcp.a.test"), ShouldBeTrue)
+		So(c.Len(), ShouldEqual, 1)
+
+		Convey("and failures are returned, but not cached", func() {
+			srvs, err := c.Fetch("missing.test")
+			So(err, ShouldBeError)
+			So(srvs, ShouldBeNil)
+			So(c.Contains("missing.test"), ShouldBeFalse)
+		})
+
+		Convey("and Removes, and Purges, forget them", func() {
+			c.Add("_ldap._tcp.a.test", []*net.SRV{{Target: "ldap.a.test", Port: 389}})
+			srvs, err := c.Fetch("_ldap._tcp.a.test")
+			So(err, ShouldBeNil)
+			So(srvs[0].Port, ShouldEqual, 389)
+			So(c.Len(), ShouldEqual, 2)
+
+			c.Remove("_ldap._tcp.a.test")
+			_, ok := c.Get("_ldap._tcp.a.test")
+			So(ok, ShouldBeFalse)
+
+			c.Purge()
+			So(c.Len(), ShouldBeZeroValue)
+			_, ok = c.Get("_http._tcp.a.test")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func Test_CacheOptions(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Cache is configured, the options of its store apply", t, func() {
+		var r srvResolver
+		c, err := NewCache(r.resolve,
+			NewConfigOption(ConfigSize, 2),
+			NewConfigOption(ConfigNegativeTTL, time.Minute),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		So(c.store, ShouldHaveSameTypeAs, &LRU{})
+
+		_, err = c.Fetch("missing.test")
+		So(err, ShouldBeError)
+		_, err = c.Fetch("missing.test")
+		var ne *NegativeError
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(r.calls.Load(), ShouldEqual, 1)
+
+		Convey("and evicted entries take their values with them", func() {
+			for _, name := range []string{"a.test", "b.test", "c.test"} {
+				_, err := c.Fetch(name)
+				So(err, ShouldBeNil)
+			}
+			So(c.Len(), ShouldEqual, 2)
+			for _, k := range c.Keys() {
+				srvs, ok := c.Get(k)
+				So(ok, ShouldBeTrue)
+				So(srvs[0].Target, ShouldEqual, "target."+k)
+			}
+		})
+	})
+
+	Convey("When the values looked up are removed at once, they are still returned", t, func() {
+		var (
+			r       srvResolver
+			remover valueRemover
+		)
+		c, err := NewCache(r.resolve, NewConfigOption(ConfigObserver, Observer(&remover)))
+		So(err, ShouldBeNil)
+		defer c.Close()
+		remover.cache = c
+
+		srvs, err := c.Fetch("a.test")
+		So(err, ShouldBeNil)
+		So(srvs[0].Target, ShouldEqual, "target.a.test")
+		So(r.calls.Load(), ShouldEqual, 1)
+		So(c.Contains("a.test"), ShouldBeFalse)
+	})
+
+	Convey("When a Cache is misconfigured, an error is returned", t, func() {
+		var r srvResolver
+		_, err := NewCache[[]*net.SRV](nil)
+		So(err, ShouldBeError)
+		_, err = NewCache(r.resolve, NewConfigOption(ConfigResolver, DefaultResolverContext))
+		So(errors.Is(err, ErrorConfigKeyUnsupported), ShouldBeTrue)
+		_, err = NewCache(r.resolve, NewConfigOption(ConfigRefreshSleepTime, "1s"))
+		So(err, ShouldBeError)
+	})
+}
+
+func Test_CacheRefresh(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Cache is refreshed, its values are looked up again", t, func() {
+		var r srvResolver
+		r.port.Store(80)
+		c, err := NewCache(r.resolve,
+			NewConfigOption(ConfigRefreshType, RefreshBatch),
+			NewConfigOption(ConfigRefreshBatchSize, 2),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		for _, name := range []string{"a.test", "b.test", "c.test"} {
+			_, err := c.Fetch(name)
+			So(err, ShouldBeNil)
+		}
+
+		r.port.Store(8080)
+		res, err := c.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Completed, ShouldBeTrue)
+		So(res.Refreshed, ShouldEqual, 3)
+		So(r.calls.Load(), ShouldEqual, 6)
+
+		srvs, err := c.Fetch("b.test")
+		So(err, ShouldBeNil)
+		So(srvs[0].Port, ShouldEqual, 8080)
+
+		c.Close()
+		_, err = c.RefreshResult(0)
+		So(err, ShouldEqual, ErrorClosed)
+	})
+}
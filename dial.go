@@ -0,0 +1,208 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	// ErrorNoAddresses is returned by DialContext when the address resolves, but not
+	// to any IPs usable by the network.
+	ErrorNoAddresses = errors.New("no suitable addresses found")
+)
+
+// defaultFallbackDelay is the Happy Eyeballs delay used when the net.Dialer doesn't specify one,
+// matching the net package.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// Dialer is a drop-in dialer that resolves hostnames via a Resolver, instead of
+// the system resolver. IP addresses are dialed in order, with Happy Eyeballs-style
+// (RFC 8305) fallback between IPv6 and IPv4 for TCP networks.
+// IPs quarantined by the Resolver are dialed last, and the outcome of each dial is
+// reported to the Resolver via ReportSuccess and ReportFailure.
+type Dialer struct {
+	// Resolver is used to resolve hostnames. Required.
+	Resolver *Resolver
+	// Dialer is used for each connection attempt. Its FallbackDelay is honored
+	// as in the net package. The zero value is usable.
+	Dialer net.Dialer
+}
+
+// Dial connects to the address on the named network.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network using the provided Context,
+// resolving the host via the Resolver. Networks other than "tcp", "tcp4", "tcp6", "udp", "udp4",
+// and "udp6" are passed through to the net.Dialer.
+// If every IP fails, the errors from each attempt are returned together.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		// literal, nothing to resolve
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := d.Resolver.FetchContext(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	primaries, fallbacks := partitionIPs(network, d.Resolver.health.order(host, ips))
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("error dialing %s: %w", address, ErrorNoAddresses)
+	}
+
+	var conn net.Conn
+	if len(fallbacks) == 0 || network[:3] == "udp" || d.Dialer.FallbackDelay < 0 {
+		conn, err = d.dialSerial(ctx, network, host, port, append(primaries, fallbacks...))
+	} else {
+		conn, err = d.dialParallel(ctx, network, host, port, primaries, fallbacks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %w", address, err)
+	}
+	return conn, nil
+}
+
+// dialSerial tries each IP in turn, returning the first connection made,
+// or all of the errors if none were.
+func (d *Dialer) dialSerial(ctx context.Context, network, host, port string, ips []net.IP) (net.Conn, error) {
+	var errs []error
+	for _, ip := range ips {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		conn, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			d.Resolver.ReportSuccess(host, ip)
+			return conn, nil
+		}
+		if ctx.Err() == nil {
+			// only the IP's fault if we weren't giving up anyway
+			d.Resolver.logger.Debug("dial failed", "address", host, "ip", ip, "error", err)
+			d.Resolver.ReportFailure(host, ip)
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dialParallel races serial dials of the primaries and the fallbacks, starting the fallbacks after
+// the FallbackDelay, or as soon as the primaries have all failed. The first connection made wins.
+func (d *Dialer) dialParallel(ctx context.Context, network, host, port string, primaries, fallbacks []net.IP) (net.Conn, error) {
+	type result struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, 2) // buffered, so losers never block
+	race := func(primary bool, ips []net.IP) {
+		conn, err := d.dialSerial(ctx, network, host, port, ips)
+		results <- result{conn: conn, err: err, primary: primary}
+	}
+
+	go race(true, primaries)
+
+	delay := d.Dialer.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	fallbackTimer := time.NewTimer(delay)
+	defer fallbackTimer.Stop()
+
+	var (
+		errs            []error
+		fallbackStarted bool
+		pending         = 1
+	)
+	for {
+		select {
+		case <-fallbackTimer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go race(false, fallbacks)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// the loser may still connect, after we've stopped caring
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go race(false, fallbacks)
+			}
+			if pending == 0 {
+				return nil, errors.Join(errs...)
+			}
+		}
+	}
+}
+
+// partitionIPs returns the IPs usable on the network, split into primaries, which are those
+// of the same family as the first usable IP, and fallbacks, which are the rest. Order is preserved.
+func partitionIPs(network string, ips []net.IP) (primaries, fallbacks []net.IP) {
+	var primaryV4 bool
+	for _, ip := range ips {
+		isV4 := ip.To4() != nil
+		switch network[len(network)-1] {
+		case '4':
+			if !isV4 {
+				continue
+			}
+		case '6':
+			if isV4 {
+				continue
+			}
+		}
+
+		if len(primaries) == 0 {
+			primaryV4 = isV4
+		}
+		if isV4 == primaryV4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// DialContext connects to the address on the named network using the provided Context,
+// resolving the host via the cache. See Dialer for details.
+// This is suitable for use as an http.Transport DialContext.
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d := Dialer{Resolver: r}
+	return d.DialContext(ctx, network, address)
+}
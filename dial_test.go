@@ -0,0 +1,156 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// listen returns a TCP listener on an ephemeral loopback port that accepts and closes connections,
+// and its port.
+func listen(network, address string) (net.Listener, string) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return l, port
+}
+
+// handCraftedResolver returns a Resolver with a single hand-crafted entry.
+func handCraftedResolver(address string, ips ...string) *Resolver {
+	c, err := cache.NewSimple(
+		cache.NewConfigOption(cache.ConfigResolver, cache.ResolverFunc(func(string) ([]net.IP, error) {
+			return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+		})),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	parsed := make([]net.IP, len(ips))
+	for i, s := range ips {
+		parsed[i] = net.ParseIP(s)
+	}
+	c.Add(address, parsed)
+
+	return NewFromConfig(&ResolverConfig{
+		Cache: c,
+	})
+}
+
+func TestDialContext(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Resolver dials a cached host, the connection is made to a cached IP.", t, func() {
+		l, port := listen("tcp4", "127.0.0.1:0")
+		defer l.Close()
+
+		r := handCraftedResolver("something.viki.io", "127.0.0.1")
+		defer r.Close()
+
+		conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("something.viki.io", port))
+		So(err, ShouldBeNil)
+		So(conn.RemoteAddr().String(), ShouldEqual, net.JoinHostPort("127.0.0.1", port))
+		conn.Close()
+
+		Convey("IP literals are dialed without resolution", func() {
+			conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port))
+			So(err, ShouldBeNil)
+			conn.Close()
+		})
+
+		Convey("Lookup errors are returned", func() {
+			_, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("invalid.viki.io", port))
+			var dnsErr *net.DNSError
+			So(errors.As(err, &dnsErr), ShouldBeTrue)
+		})
+	})
+
+	Convey("When a Resolver dials a host whose first IPs refuse, the next IPs are tried.", t, func() {
+		l, port := listen("tcp4", "127.0.0.1:0")
+		defer l.Close()
+
+		r := handCraftedResolver("something.viki.io", "::1", "127.0.0.2", "127.0.0.1")
+		defer r.Close()
+
+		conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("something.viki.io", port))
+		So(err, ShouldBeNil)
+		So(conn.RemoteAddr().String(), ShouldEqual, net.JoinHostPort("127.0.0.1", port))
+		conn.Close()
+	})
+
+	Convey("When a Resolver dials a host whose primary family hangs, the fallback family wins.", t, func() {
+		l, port := listen("tcp6", "[::1]:0")
+		defer l.Close()
+
+		// 192.0.2.0/24 is TEST-NET-1, so the v4 dial will never succeed promptly
+		r := handCraftedResolver("something.viki.io", "192.0.2.1", "::1")
+		defer r.Close()
+
+		d := &Dialer{
+			Resolver: r,
+			Dialer: net.Dialer{
+				FallbackDelay: 10 * time.Millisecond,
+			},
+		}
+		conn, err := d.Dial("tcp", net.JoinHostPort("something.viki.io", port))
+		So(err, ShouldBeNil)
+		So(conn.RemoteAddr().String(), ShouldEqual, net.JoinHostPort("::1", port))
+		conn.Close()
+	})
+
+	Convey("When a Resolver dials with a family-specific network, only IPs of that family are used.", t, func() {
+		r := handCraftedResolver("something.viki.io", "::1")
+		defer r.Close()
+
+		_, err := r.DialContext(context.Background(), "tcp4", "something.viki.io:80")
+		So(errors.Is(err, ErrorNoAddresses), ShouldBeTrue)
+	})
+
+	Convey("When every IP refuses, all of the errors are returned.", t, func() {
+		l, port := listen("tcp4", "127.0.0.1:0")
+		l.Close() // nobody home
+
+		r := handCraftedResolver("something.viki.io", "127.0.0.1", "::1")
+		defer r.Close()
+
+		_, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("something.viki.io", port))
+		So(err, ShouldBeError)
+		var opErr *net.OpError
+		So(errors.As(err, &opErr), ShouldBeTrue)
+	})
+}
+
+func TestPartitionIPs(t *testing.T) {
+	Convey("When IPs are partitioned, the families and order are as expected.", t, func() {
+		ips := []net.IP{net.ParseIP("::1"), net.ParseIP("1.1.2.3"), net.ParseIP("::2"), net.ParseIP("1.1.2.4")}
+
+		p, f := partitionIPs("tcp", ips)
+		So(p, ShouldResemble, []net.IP{ips[0], ips[2]})
+		So(f, ShouldResemble, []net.IP{ips[1], ips[3]})
+
+		p, f = partitionIPs("tcp4", ips)
+		So(p, ShouldResemble, []net.IP{ips[1], ips[3]})
+		So(f, ShouldBeEmpty)
+
+		p, f = partitionIPs("udp6", ips)
+		So(p, ShouldResemble, []net.IP{ips[0], ips[2]})
+		So(f, ShouldBeEmpty)
+	})
+}
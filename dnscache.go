@@ -7,7 +7,11 @@
 package dnscache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"net"
 	"time"
 
@@ -26,11 +30,26 @@ var (
 	RefreshShuffle = true
 )
 
+const (
+	// minExpiryWait is the shortest an auto-refresh will wait for an expiring entry.
+	minExpiryWait = 100 * time.Millisecond
+	// expiryPollInterval is the longest an auto-refresh will wait before checking
+	// an expiring cache for new entries.
+	expiryPollInterval = 1 * time.Second
+)
+
 // Resolver is a goro-safe caching DNS resolver.
 type Resolver struct {
-	cache  ResolverCache
-	config *ResolverConfig
-	done   chan struct{}
+	cache     ResolverCache
+	config    *ResolverConfig
+	done      chan struct{}
+	selector  *selector
+	health    *health
+	watchers  *watchers
+	unobserve func()
+	logger    *slog.Logger
+	refresher *refresher
+	records   *records
 }
 
 // New returns a properly instantiated Resolver.
@@ -66,50 +85,148 @@ func NewWithRefreshTimeout(refreshRate, refreshTimeout time.Duration) *Resolver
 // and the provided AutoRefresh* values.
 // NOTE: If using an LRU-style cache, setting the AutoRefreshInterval as large as
 // feasible is advised, to keep the cache calculus correct.
+// If the Cache honors record TTLs (see cache.ConfigRecordTTL), the AutoRefreshInterval is the
+// longest the auto-refresh will wait, and passes will run as entries expire. Entries whose TTLs
+// are unknown, as they always are with the default resolver, are refreshed at the AutoRefreshInterval.
 func NewFromConfig(config *ResolverConfig) *Resolver {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	selection := config.Selection
+	if !selection.supported() {
+		logger.Error("unsupported Selection, using SelectFirst", "selection", selection)
+		selection = SelectFirst
+	}
+
 	if config.Cache == nil {
 		// cache wasn't specified. Why is this constructor called?!
-		c, _ := cache.NewSimple() // defaults, no error trap needed
+		c, _ := cache.NewSimple(cache.NewConfigOption(cache.ConfigLogger, logger)) // defaults, no error trap needed
 		config.Cache = c
 	}
 
 	resolver := &Resolver{
-		cache:  config.Cache,
-		config: config,
-		done:   make(chan struct{}),
+		cache:     config.Cache,
+		config:    config,
+		done:      make(chan struct{}),
+		selector:  newSelector(selection, config.Weights),
+		health:    newHealth(config.Quarantine, config.QuarantineMax),
+		logger:    logger,
+		refresher: newRefresher(config.AutoRefreshInterval, config.AutoRefreshTimeout),
+		records:   newRecords(config, logger),
+	}
+
+	if oc, ok := config.Cache.(cache.ObservableCache); ok {
+		resolver.watchers = newWatchers(config.Family)
+		resolver.unobserve = oc.Observe(resolver.watchers)
 	}
 
 	if config.AutoRefreshInterval > 0 {
-		go resolver.autoRefreshTimeout(config.AutoRefreshInterval, config.AutoRefreshTimeout)
+		resolver.startAutoRefresh()
 	}
 
 	return resolver
 }
 
-// Close signals the auto-refresh goro, if any, to quit.
+// Close signals the auto-refresh goro, if any, to quit, closes any Watch channels, and closes the caches,
+// which cancels any Refresh pass in progress. The auto-refresh goro is waited for.
 // This is safe to call once, in any thread, regardless of whether or not auto-refresh is used.
 func (r *Resolver) Close() error {
 	close(r.done)
-	return r.cache.Close()
+	if r.unobserve != nil {
+		r.unobserve()
+		r.watchers.close()
+	}
+	err := r.cache.Close()
+	r.records.close()
+	r.stopAutoRefresh()
+	return err
 }
 
 // Fetch returns a collection of IPs from cache, or a live lookup if not.
 func (r *Resolver) Fetch(address string) ([]net.IP, error) {
-	return r.cache.Fetch(address)
+	return r.FetchContext(context.Background(), address)
+}
+
+// FetchContext returns a collection of IPs from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any.
+// IPs are filtered, or ordered, according to the configured Family.
+func (r *Resolver) FetchContext(ctx context.Context, address string) ([]net.IP, error) {
+	return r.FetchFamily(ctx, address, r.config.Family)
+}
+
+// FetchFamily returns a collection of IPs of the specified Family from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any, if the cache is a cache.ContextCache.
+func (r *Resolver) FetchFamily(ctx context.Context, address string, family cache.Family) ([]net.IP, error) {
+	var (
+		ips []net.IP
+		err error
+	)
+	if cc, ok := r.cache.(cache.ContextCache); ok {
+		ips, err = cc.FetchContext(ctx, address)
+	} else {
+		ips, err = r.cache.Fetch(address)
+	}
+	if err != nil || family == cache.FamilyAny {
+		return ips, err
+	}
+	return cache.FilterIPs(family, ips...), nil
+}
+
+// FetchStale returns a collection of IPs from cache, or a live lookup if not, and true if
+// the collection is a stale entry, served because the live lookup failed.
+// If the cache is not a cache.StaleCache, this is FetchContext and never stale.
+// IPs are filtered, or ordered, according to the configured Family.
+func (r *Resolver) FetchStale(ctx context.Context, address string) ([]net.IP, bool, error) {
+	sc, ok := r.cache.(cache.StaleCache)
+	if !ok {
+		ips, err := r.FetchContext(ctx, address)
+		return ips, false, err
+	}
+
+	ips, stale, err := sc.FetchStale(ctx, address)
+	if err != nil || r.config.Family == cache.FamilyAny {
+		return ips, stale, err
+	}
+	return cache.FilterIPs(r.config.Family, ips...), stale, nil
+}
+
+// GetEntry returns the cache.Entry for the address, and true, or false if there is none, or the cache
+// is not a cache.EntryCache. No lookup is made, and the IPs are not filtered by Family.
+func (r *Resolver) GetEntry(address string) (cache.Entry, bool) {
+	ec, ok := r.cache.(cache.EntryCache)
+	if !ok {
+		return cache.Entry{}, false
+	}
+	return ec.GetEntry(address)
 }
 
 // FetchOne returns a single IP from cache, or a live lookup if not.
+// The IP is chosen according to the configured Selection, avoiding IPs quarantined by ReportFailure.
 func (r *Resolver) FetchOne(address string) (net.IP, error) {
-	ips, err := r.Fetch(address)
+	return r.FetchOneContext(context.Background(), address)
+}
+
+// FetchOneContext returns a single IP from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any.
+func (r *Resolver) FetchOneContext(ctx context.Context, address string) (net.IP, error) {
+	ips, err := r.FetchContext(ctx, address)
 	if err != nil || len(ips) == 0 {
 		return nil, err
 	}
-	return ips[0], nil
+	return r.selector.pick(address, r.health.admit(address, ips)), nil
 }
 
 // FetchOneString returns a single IP -as a string- from cache, or a live lookup if not.
 func (r *Resolver) FetchOneString(address string) (string, error) {
-	ip, err := r.FetchOne(address)
+	return r.FetchOneStringContext(context.Background(), address)
+}
+
+// FetchOneStringContext returns a single IP -as a string- from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any.
+func (r *Resolver) FetchOneStringContext(ctx context.Context, address string) (string, error) {
+	ip, err := r.FetchOneContext(ctx, address)
 	if err != nil || ip == nil {
 		return "", err
 	}
@@ -117,38 +234,142 @@ func (r *Resolver) FetchOneString(address string) (string, error) {
 }
 
 // Refresh will iterate over cache items, and performing a live lookup one every RefreshSleepTime.
+// If an auto-refresh pass is in progress, Refresh waits for it to finish first.
 func (r *Resolver) Refresh() {
-	r.cache.Refresh(0)
+	r.refreshResult(0)
 }
 
 // RefreshTimeout will iterate over cache items, and performing a live lookup one every RefreshSleepTime,
 // until completed or the stated timeout expires.
+// If an auto-refresh pass is in progress, RefreshTimeout waits for it to finish first.
 func (r *Resolver) RefreshTimeout(timeout time.Duration) {
-	r.cache.Refresh(timeout)
+	r.refreshResult(timeout)
+}
+
+// RefreshResult will iterate over cache items, and performing a live lookup one every RefreshSleepTime,
+// until completed or the stated timeout, if non-zero, expires. The outcome of the pass is returned,
+// including any lookup failures, or an error if the pass could not be made. If the cache is not a
+// cache.ResultCache, only the Took of the outcome is known.
+// If an auto-refresh pass is in progress, RefreshResult waits for it to finish first.
+func (r *Resolver) RefreshResult(timeout time.Duration) (cache.RefreshResult, error) {
+	return r.refreshResult(timeout)
 }
 
 // Lookup returns a collection of IPs from a live lookup, and updates the cache.
 // Most callers should use one of the Fetch functions.
 func (r *Resolver) Lookup(address string) ([]net.IP, error) {
+	return r.LookupContext(context.Background(), address)
+}
+
+// LookupContext returns a collection of IPs from a live lookup, honoring the Context if the cache
+// is a cache.ContextCache, and updates the cache.
+// Most callers should use one of the Fetch functions.
+func (r *Resolver) LookupContext(ctx context.Context, address string) ([]net.IP, error) {
+	if cc, ok := r.cache.(cache.ContextCache); ok {
+		return cc.LookupContext(ctx, address)
+	}
 	return r.cache.Lookup(address)
 }
 
+// Stats returns a snapshot of the statistics of the cache, or zero Stats if the cache is not a cache.StatsCache.
+func (r *Resolver) Stats() cache.Stats {
+	if sc, ok := r.cache.(cache.StatsCache); ok {
+		return sc.Stats()
+	}
+	return cache.Stats{}
+}
+
 // Purge will remove all entries. To comply with ResolverCache.
 func (r *Resolver) Purge() {
+	r.selector.forget()
+	r.health.forget()
 	r.cache.Purge()
+	r.records.purge()
 }
 
-// autoRefresh is an internal loop to Refresh every declared interval.
+// autoRefresh is an internal loop to Refresh every interval, plus any jitter, or sooner
+// if the cache is an expiring cache.ExpiringCache with an entry expiring before then,
+// or if TriggerRefresh is called. The interval and timeout are those last set, and no passes
+// are made at the interval while paused, or if it is 0.
 // The loop terminates if Close is called.
-// The specified timeout is passed on to each Refresh iteration, or 0 for
-// no timeout.
-func (r *Resolver) autoRefreshTimeout(rate, timeout time.Duration) {
+func (r *Resolver) autoRefresh() {
+	var (
+		started  = time.Now()
+		finished = started
+		jitter   = r.jitter()
+	)
 	for {
 		select {
-		case <-time.After(rate):
-			r.cache.Refresh(timeout)
 		case <-r.done:
+			r.logger.Debug("auto-refresh stopped")
+			return
+		default:
+		}
+
+		rate, timeout, paused := r.refresher.settings()
+		var (
+			due  <-chan time.Time // nil never fires
+			poll bool
+		)
+		if rate > 0 && !paused {
+			var wait time.Duration
+			wait, poll = r.nextRefresh(rate+jitter, started, finished)
+			due = time.After(wait)
+		}
+
+		select {
+		case <-due:
+			if poll {
+				// nothing was due, but entries may have been added since
+				continue
+			}
+		case <-r.refresher.trigger:
+		case <-r.refresher.wake:
+			// the settings changed
+			continue
+		case <-r.done:
+			r.logger.Debug("auto-refresh stopped")
 			return
 		}
+
+		started = time.Now()
+		if _, err := r.refreshResult(timeout); errors.Is(err, context.Canceled) || errors.Is(err, cache.ErrorClosed) {
+			r.logger.Debug("auto-refresh pass cancelled", "error", err)
+		} else if err != nil {
+			r.logger.Error("auto-refresh pass failed", "error", err)
+		}
+		finished = time.Now()
+		jitter = r.jitter()
+		r.logger.Debug("auto-refresh pass finished", "took", finished.Sub(started), "jitter", jitter)
+	}
+}
+
+// jitter returns a random Duration up to the AutoRefreshJitter, to add to the next interval.
+func (r *Resolver) jitter() time.Duration {
+	if r.config.AutoRefreshJitter <= 0 {
+		return 0
+	}
+	return rand.N(r.config.AutoRefreshJitter + 1)
+}
+
+// nextRefresh returns how long to wait before the next auto-refresh pass, given when the
+// last one started and finished, and true if the wait is only a poll, with no pass due after it.
+// For expiring caches, the wait is until the next entry expiry since the last pass started, if that is sooner,
+// but never less than minExpiryWait, nor more than expiryPollInterval, so that newly-added entries are noticed.
+// Entries which expired before the last pass started were visited by it, and are not due until the rate.
+func (r *Resolver) nextRefresh(rate time.Duration, started, finished time.Time) (time.Duration, bool) {
+	wait := time.Until(finished.Add(rate))
+
+	ec, ok := r.cache.(cache.ExpiringCache)
+	if !ok || !ec.Expiring() {
+		return wait, false
+	}
+
+	if next := ec.NextExpiry(started); !next.IsZero() {
+		wait = min(wait, max(time.Until(next), minExpiryWait))
+	}
+	if wait > expiryPollInterval {
+		return expiryPollInterval, true
 	}
+	return wait, false
 }
@@ -1,11 +1,12 @@
 package dnscache
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"slices"
-	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -78,20 +79,38 @@ func ExampleNewFromConfig() {
 	fmt.Printf("%+v\n", ips)
 }
 
-// If you are using an `http.Transport`, you can use this cache by specifying a `Dial` function.
+// If you are using an `http.Transport`, you can use this cache by specifying its `DialContext` function.
 func Example() {
 	// Create a resolver somewhere
 	resolver := New(5 * time.Minute)
 
 	transport := &http.Transport{
 		MaxIdleConnsPerHost: 64,
-		Dial: func(network string, address string) (net.Conn, error) {
-			separator := strings.LastIndex(address, ":")
-			ip, _ := resolver.FetchOneString(address[:separator])
-			return net.Dial("tcp", ip+address[separator:])
+		DialContext:         resolver.DialContext,
+	}
+
+	// e.g.
+	http.DefaultTransport = transport
+}
+
+// If you need control over the connections, use a Dialer.
+func ExampleDialer() {
+	// Create a resolver somewhere
+	resolver := New(5 * time.Minute)
+
+	dialer := &Dialer{
+		Resolver: resolver,
+		Dialer: net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
 		},
 	}
 
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: 64,
+		DialContext:         dialer.DialContext,
+	}
+
 	// e.g.
 	http.DefaultTransport = transport
 }
@@ -172,6 +191,38 @@ func TestFetchOneLoadsTheFirstValue(t *testing.T) {
 	})
 }
 
+func TestFetchFamily(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache entry has both families, the Fetch functions serve the configured Family.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:  c,
+			Family: cache.FamilyPreferIPv4,
+		})
+		defer r.Close()
+
+		c.Add("something.viki.io", []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("100.100.102.103")})
+
+		ip, _ := r.FetchOneString("something.viki.io")
+		So(ip, ShouldEqual, "100.100.102.103")
+
+		ips, _ := r.FetchFamily(context.Background(), "something.viki.io", cache.FamilyIPv6)
+		So(ips, ShouldHaveLength, 1)
+		So(ips[0].String(), ShouldEqual, "2001:db8::1")
+
+		ips, _ = r.FetchFamily(context.Background(), "something.viki.io", cache.FamilyAny)
+		So(ips, ShouldHaveLength, 2)
+
+		Convey("and the cache keeps both families", func() {
+			ips, _ := c.Get("something.viki.io")
+			So(ips, ShouldHaveLength, 2)
+		})
+	})
+}
+
 func TestFetchOneStringLoadsTheFirstValue(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -208,6 +259,113 @@ func TestFetchLoadsTheIpAndCachesIt(t *testing.T) {
 	})
 }
 
+func TestFetchContextHonorsTheContext(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Resolver uses a context-aware resolver, and the Context is canceled, the lookup is abandoned.", t, func() {
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverContextFunc(func(ctx context.Context, address string) ([]net.IP, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})),
+		)
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		ip, err := r.FetchOneStringContext(ctx, "dns.google.com")
+		So(ip, ShouldBeEmpty)
+		So(err, ShouldEqual, context.DeadlineExceeded)
+
+		ips, err := r.LookupContext(ctx, "dns.google.com")
+		So(ips, ShouldBeNil)
+		So(err, ShouldEqual, context.DeadlineExceeded)
+	})
+}
+
+func TestStats(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache is used, its Stats are those of its cache.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		c.Add("something.viki.io", stringsToIPs("1.1.2.3"))
+		r.FetchOne("something.viki.io")
+		r.FetchOneString("something.viki.io")
+
+		So(r.Stats().Hits, ShouldEqual, 2)
+		So(r.Stats(), ShouldResemble, c.Stats())
+	})
+}
+
+func TestRefreshResult(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache is refreshed, the outcome of the pass is returned.", t, func() {
+		var fake fakeResolver
+
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverTTLFunc(fake.resolve)),
+			cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		r.Fetch("something.viki.io")
+		res, err := r.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Completed, ShouldBeTrue)
+		So(res.Keys, ShouldEqual, 1)
+		So(res.Refreshed, ShouldEqual, 1)
+	})
+}
+
+func TestGetEntry(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache has an entry, its metadata can be gotten without a lookup.", t, func() {
+		var fake fakeResolver
+
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverTTLFunc(fake.resolve)),
+			cache.NewConfigOption(cache.ConfigResolverName, "test"),
+		)
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		_, ok := r.GetEntry("something.viki.io")
+		So(ok, ShouldBeFalse)
+
+		r.Fetch("something.viki.io")
+		r.Fetch("something.viki.io")
+		e, ok := r.GetEntry("something.viki.io")
+		So(ok, ShouldBeTrue)
+		So(e.IPs, ShouldResemble, stringsToIPs("1.1.2.3"))
+		So(e.Source, ShouldEqual, "test")
+		So(e.Hits, ShouldEqual, 1)
+	})
+}
+
 func TestNewFromCacheNilCache(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -309,12 +467,178 @@ func TestAGTimeout(t *testing.T) {
 	})
 }
 
+func TestAutoRefreshHonorsRecordTTLs(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache honors record TTLs, the auto-refresh runs as entries expire, not at the interval.", t, func() {
+		fake := fakeResolver{ttl: 150 * time.Millisecond}
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverTTLFunc(fake.resolve)),
+			cache.NewConfigOption(cache.ConfigRecordTTL, true),
+			cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:               c,
+			AutoRefreshInterval: time.Hour,
+		})
+		defer r.Close()
+
+		wait, poll := r.nextRefresh(time.Hour, time.Now(), time.Now())
+		So(poll, ShouldBeTrue)
+		So(wait, ShouldEqual, expiryPollInterval)
+
+		r.Fetch("something.viki.io")
+		wait, poll = r.nextRefresh(time.Hour, time.Now(), time.Now())
+		So(poll, ShouldBeFalse)
+		So(wait, ShouldBeGreaterThanOrEqualTo, minExpiryWait)
+		So(wait, ShouldBeLessThanOrEqualTo, 150*time.Millisecond)
+
+		time.Sleep(1500 * time.Millisecond)
+		So(fake.calls.Load(), ShouldBeGreaterThan, 1)
+	})
+
+	Convey("When a DNSCache honors record TTLs, but the default resolver cannot know them, the auto-refresh runs at the interval.", t, func() {
+		var calls atomic.Int32
+		resolver := cache.DefaultResolverContext
+		defer func() { cache.DefaultResolverContext = resolver }()
+		cache.DefaultResolverContext = func(ctx context.Context, address string) ([]net.IP, error) {
+			calls.Add(1)
+			return stringsToIPs("1.1.2.3"), nil
+		}
+
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigRecordTTL, true),
+			cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:               c,
+			AutoRefreshInterval: 200 * time.Millisecond,
+		})
+		defer r.Close()
+
+		r.Fetch("something.viki.io")
+		wait, poll := r.nextRefresh(200*time.Millisecond, time.Now(), time.Now())
+		So(poll, ShouldBeFalse)
+		So(wait, ShouldBeGreaterThan, 150*time.Millisecond)
+
+		time.Sleep(500 * time.Millisecond)
+		So(calls.Load(), ShouldBeGreaterThan, 1)
+	})
+}
+
+func TestAutoRefreshJitter(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache has an AutoRefreshJitter, each interval is lengthened by up to that much, at random.", t, func() {
+		r := NewFromConfig(&ResolverConfig{
+			AutoRefreshJitter: 10 * time.Millisecond,
+		})
+		defer r.Close()
+
+		seen := make(map[time.Duration]bool)
+		for range 100 {
+			d := r.jitter()
+			So(d, ShouldBeBetweenOrEqual, 0, 10*time.Millisecond)
+			seen[d] = true
+		}
+		So(len(seen), ShouldBeGreaterThan, 1)
+
+		r.config.AutoRefreshJitter = 0
+		So(r.jitter(), ShouldBeZeroValue)
+	})
+}
+
+func TestFetchStale(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a DNSCache serves stale, and the lookup of an expired entry fails, the stale entry is returned and flagged.", t, func() {
+		fake := fakeResolver{ttl: time.Millisecond}
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverTTLFunc(fake.resolve)),
+			cache.NewConfigOption(cache.ConfigRecordTTL, true),
+			cache.NewConfigOption(cache.ConfigServeStale, time.Minute),
+		)
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+
+		ips, stale, err := r.FetchStale(context.Background(), "something.viki.io")
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeFalse)
+		So(ips, ShouldResemble, stringsToIPs("1.1.2.3"))
+
+		fake.fail.Store(true)
+		time.Sleep(5 * time.Millisecond)
+
+		ips, stale, err = r.FetchStale(context.Background(), "something.viki.io")
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeTrue)
+		So(ips, ShouldResemble, stringsToIPs("1.1.2.3"))
+	})
+}
+
 func TestResolverCaches(t *testing.T) {
 	Convey("The provided caches implement ResolverCache", t, func() {
 		r := &cache.Simple{}
 		SoMsg("cache.Simple is no longer a ResolverCache!", r, ShouldImplement, (*ResolverCache)(nil))
 		l := &cache.LRU{}
 		SoMsg("cache,LRU is no longer a ResolverCache!", l, ShouldImplement, (*ResolverCache)(nil))
+
+		for _, c := range []ResolverCache{r, l} {
+			So(c, ShouldImplement, (*cache.ContextCache)(nil))
+			So(c, ShouldImplement, (*cache.ResultCache)(nil))
+			So(c, ShouldImplement, (*cache.StatsCache)(nil))
+		}
+	})
+}
+
+// basicCache is a ResolverCache that implements none of the optional interfaces.
+type basicCache struct {
+	c *cache.Simple
+}
+
+func (b basicCache) Fetch(address string) ([]net.IP, error)  { return b.c.Fetch(address) }
+func (b basicCache) Lookup(address string) ([]net.IP, error) { return b.c.Lookup(address) }
+func (b basicCache) Purge()                                  { b.c.Purge() }
+func (b basicCache) Refresh(timeout time.Duration)           { b.c.Refresh(timeout) }
+func (b basicCache) Close() error                            { return b.c.Close() }
+func (b basicCache) Add(address string, ips []net.IP)        { b.c.Add(address, ips) }
+func (b basicCache) Remove(address string)                   { b.c.Remove(address) }
+func (b basicCache) Get(address string) ([]net.IP, bool)     { return b.c.Get(address) }
+func (b basicCache) Len() int                                { return b.c.Len() }
+
+func TestResolverBasicCache(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Resolver has a cache with only the ResolverCache methods, they are used instead", t, func() {
+		s, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverContextFunc(
+				func(ctx context.Context, address string) ([]net.IP, error) {
+					return stringsToIPs("1.1.2.3"), nil
+				})),
+		)
+		So(err, ShouldBeNil)
+		r := NewFromConfig(&ResolverConfig{Cache: basicCache{c: s}})
+		defer r.Close()
+
+		ips, err := r.FetchContext(context.Background(), "something.viki.io")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, stringsToIPs("1.1.2.3"))
+		ips, err = r.LookupContext(context.Background(), "something.viki.io")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, stringsToIPs("1.1.2.3"))
+
+		res, err := r.RefreshResult(0)
+		So(err, ShouldBeNil)
+		So(res.Took, ShouldBeGreaterThan, 0)
+		So(r.Stats(), ShouldBeZeroValue)
 	})
 }
 
@@ -0,0 +1,190 @@
+package dnscache
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultQuarantine is the first quarantine of a failed IP, if the ResolverConfig doesn't specify one.
+	defaultQuarantine = 5 * time.Second
+	// defaultQuarantineMax is the longest quarantine of a failed IP, if the ResolverConfig doesn't specify one.
+	defaultQuarantineMax = 5 * time.Minute
+)
+
+// ipHealth is the failure history of one IP of an address.
+type ipHealth struct {
+	failures int
+	until    time.Time
+}
+
+// health is a goro-safe tracker of IPs reported as failing, per address.
+// Each consecutive failure doubles the quarantine of the IP, up to the max.
+// A success clears the history of the IP, as does the passing of the max after its quarantine ends,
+// so that IPs which fail once, and are never heard of again, are not remembered forever.
+type health struct {
+	lock       sync.Mutex
+	addresses  map[string]map[string]*ipHealth
+	quarantine time.Duration
+	max        time.Duration
+	pruned     time.Time // when the histories were last pruned
+}
+
+// newHealth returns a health, with defaults for unset durations.
+func newHealth(quarantine, longest time.Duration) *health {
+	if quarantine <= 0 {
+		quarantine = defaultQuarantine
+	}
+	if longest <= 0 {
+		longest = defaultQuarantineMax
+	}
+	return &health{
+		addresses:  make(map[string]map[string]*ipHealth),
+		quarantine: quarantine,
+		max:        longest,
+	}
+}
+
+// failure records a failure of the IP for the address, quarantining it, and returns
+// the number of consecutive failures and the quarantine.
+func (h *health) failure(address string, ip net.IP) (int, time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.pruned) >= h.max {
+		h.prune(now)
+	}
+
+	ips, ok := h.addresses[address]
+	if !ok {
+		ips = make(map[string]*ipHealth)
+		h.addresses[address] = ips
+	}
+	ih, ok := ips[ip.String()]
+	if !ok {
+		ih = &ipHealth{}
+		ips[ip.String()] = ih
+	}
+
+	ih.failures++
+	q := h.quarantine
+	for i := 1; i < ih.failures && q < h.max; i++ {
+		q *= 2
+	}
+	q = min(q, h.max)
+	ih.until = now.Add(q)
+	return ih.failures, q
+}
+
+// prune drops the histories of the IPs whose quarantines ended over the max ago.
+// The lock must be held.
+func (h *health) prune(now time.Time) {
+	for address, ips := range h.addresses {
+		for ip, ih := range ips {
+			if now.Sub(ih.until) > h.max {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(h.addresses, address)
+		}
+	}
+	h.pruned = now
+}
+
+// success clears the history of the IP for the address.
+func (h *health) success(address string, ip net.IP) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ips, ok := h.addresses[address]
+	if !ok {
+		return
+	}
+	delete(ips, ip.String())
+	if len(ips) == 0 {
+		delete(h.addresses, address)
+	}
+}
+
+// quarantined returns true if the IP for the address is currently quarantined.
+// The lock must be held.
+func (h *health) quarantined(ips map[string]*ipHealth, ip net.IP, now time.Time) bool {
+	ih, ok := ips[ip.String()]
+	return ok && now.Before(ih.until)
+}
+
+// admit returns the IPs for the address that are not quarantined, or all of them if every one is.
+func (h *health) admit(address string, ips []net.IP) []net.IP {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	history, ok := h.addresses[address]
+	if !ok {
+		return ips
+	}
+
+	var (
+		now      = time.Now()
+		admitted = make([]net.IP, 0, len(ips))
+	)
+	for _, ip := range ips {
+		if !h.quarantined(history, ip, now) {
+			admitted = append(admitted, ip)
+		}
+	}
+	if len(admitted) == 0 {
+		// better a bad IP than no IP
+		return ips
+	}
+	return admitted
+}
+
+// order returns the IPs for the address with the quarantined ones last, as a last resort.
+// Order is otherwise preserved.
+func (h *health) order(address string, ips []net.IP) []net.IP {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	history, ok := h.addresses[address]
+	if !ok {
+		return ips
+	}
+
+	var (
+		now     = time.Now()
+		ordered = make([]net.IP, 0, len(ips))
+		last    []net.IP
+	)
+	for _, ip := range ips {
+		if h.quarantined(history, ip, now) {
+			last = append(last, ip)
+		} else {
+			ordered = append(ordered, ip)
+		}
+	}
+	return append(ordered, last...)
+}
+
+// forget drops the history of all addresses.
+func (h *health) forget() {
+	h.lock.Lock()
+	h.addresses = make(map[string]map[string]*ipHealth)
+	h.lock.Unlock()
+}
+
+// ReportFailure quarantines the IP for the address, so that FetchOne, FetchOneString, and Dialers
+// avoid it while other IPs for the address are available. Consecutive failures double the quarantine,
+// up to the ResolverConfig QuarantineMax. Failures are forgotten once the QuarantineMax has passed
+// since the quarantine ended. The cache entry is untouched.
+func (r *Resolver) ReportFailure(address string, ip net.IP) {
+	failures, q := r.health.failure(address, ip)
+	r.logger.Info("IP quarantined", "address", address, "ip", ip, "failures", failures, "quarantine", q)
+}
+
+// ReportSuccess clears any failures of the IP for the address, re-admitting it immediately.
+func (r *Resolver) ReportSuccess(address string, ip net.IP) {
+	r.health.success(address, ip)
+}
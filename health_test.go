@@ -0,0 +1,132 @@
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReportFailure(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ips := stringsToIPs("1.1.2.3", "1.1.2.4")
+
+	Convey("When an IP is reported as failing, FetchOne avoids it until its quarantine ends.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:      c,
+			Quarantine: 20 * time.Millisecond,
+		})
+		defer r.Close()
+		c.Add("something.viki.io", ips)
+
+		r.ReportFailure("something.viki.io", ips[0])
+		for range 5 {
+			ip, _ := r.FetchOne("something.viki.io")
+			So(ip, ShouldResemble, ips[1])
+		}
+		ips, _ := c.Get("something.viki.io")
+		So(ips, ShouldHaveLength, 2)
+
+		time.Sleep(30 * time.Millisecond)
+		ip, _ := r.FetchOne("something.viki.io")
+		So(ip, ShouldResemble, ips[0])
+
+		Convey("and if every IP is quarantined, they are all used anyway", func() {
+			r.ReportFailure("something.viki.io", ips[0])
+			r.ReportFailure("something.viki.io", ips[1])
+			ip, _ := r.FetchOne("something.viki.io")
+			So(ip, ShouldResemble, ips[0])
+		})
+
+		Convey("and a success re-admits the IP immediately", func() {
+			r.ReportFailure("something.viki.io", ips[0])
+			r.ReportSuccess("something.viki.io", ips[0])
+			ip, _ := r.FetchOne("something.viki.io")
+			So(ip, ShouldResemble, ips[0])
+		})
+	})
+}
+
+func TestHealthBackoff(t *testing.T) {
+	Convey("When an IP fails consecutively, its quarantine doubles, up to the max.", t, func() {
+		h := newHealth(time.Second, 5*time.Second)
+		ip := net.ParseIP("1.1.2.3")
+
+		expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+		for i, e := range expected {
+			failures, q := h.failure("something.viki.io", ip)
+			So(failures, ShouldEqual, i+1)
+			So(q, ShouldEqual, e)
+			until := h.addresses["something.viki.io"][ip.String()].until
+			So(until, ShouldHappenWithin, 10*time.Millisecond, time.Now().Add(e))
+		}
+
+		h.success("something.viki.io", ip)
+		So(h.addresses, ShouldBeEmpty)
+	})
+
+	Convey("When the quarantine of an IP ended over the max ago, its history is pruned by the next failure.", t, func() {
+		h := newHealth(time.Millisecond, 10*time.Millisecond)
+		ip := net.ParseIP("1.1.2.3")
+
+		h.failure("something.viki.io", ip)
+		h.failure("another.viki.io", ip)
+		So(h.addresses, ShouldHaveLength, 2)
+
+		time.Sleep(25 * time.Millisecond)
+		failures, q := h.failure("another.viki.io", ip)
+		So(failures, ShouldEqual, 1) // no longer consecutive
+		So(q, ShouldEqual, time.Millisecond)
+		So(h.addresses, ShouldHaveLength, 1)
+		So(h.addresses, ShouldContainKey, "another.viki.io")
+	})
+}
+
+func TestDialReportsFailures(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Dialer fails to connect to an IP, it is quarantined, and dialed last next time.", t, func() {
+		l, port := listen("tcp4", "127.0.0.1:0")
+		defer l.Close()
+
+		r := handCraftedResolver("something.viki.io", "127.0.0.2", "127.0.0.1")
+		defer r.Close()
+
+		conn, err := r.DialContext(context.Background(), "tcp4", net.JoinHostPort("something.viki.io", port))
+		So(err, ShouldBeNil)
+		conn.Close()
+
+		ip, _ := r.FetchOneString("something.viki.io")
+		So(ip, ShouldEqual, "127.0.0.1")
+		So(r.health.order("something.viki.io", stringsToIPs("127.0.0.2", "127.0.0.1")), ShouldResemble, stringsToIPs("127.0.0.1", "127.0.0.2"))
+	})
+}
+
+func TestReportFailureLogs(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When an IP is reported as failing, its quarantine is logged.", t, func() {
+		var buf bytes.Buffer
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:  c,
+			Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+		})
+		defer r.Close()
+
+		r.ReportFailure("something.viki.io", net.ParseIP("1.1.2.3"))
+		So(buf.String(), ShouldContainSubstring, `level=INFO msg="IP quarantined" address=something.viki.io ip=1.1.2.3 failures=1 quarantine=5s`)
+	})
+}
@@ -0,0 +1,40 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// fakeResolver is the resolver of tests. It counts its lookups, and answers with the IPs stored in
+// answer, or 1.1.2.3 if none are, and the ttl. While fail is true, it fails with "boom".
+type fakeResolver struct {
+	ttl time.Duration
+
+	calls  atomic.Int32
+	fail   atomic.Bool
+	answer atomic.Value // []net.IP
+}
+
+// resolve is the cache.ResolverTTLFunc of the fakeResolver.
+func (f *fakeResolver) resolve(ctx context.Context, address string) ([]net.IP, time.Duration, error) {
+	f.calls.Add(1)
+	if f.fail.Load() {
+		return nil, f.ttl, errors.New("boom")
+	}
+	if answer, ok := f.answer.Load().([]net.IP); ok {
+		return answer, f.ttl, nil
+	}
+	return stringsToIPs("1.1.2.3"), f.ttl, nil
+}
+
+// waitFor polls the condition until it is true, or a second has passed, returning the last result.
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !condition() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return condition()
+}
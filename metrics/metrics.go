@@ -0,0 +1,176 @@
+// Package metrics exports the statistics of dnscache caches to Prometheus, via prometheus.Collectors.
+//
+// A Collector gathers the metrics of the cache of one named Resolver, labeling them with the name,
+// so that the Collectors of several Resolvers can be registered with the same prometheus.Registerer.
+// A Collector is also a cache.Recorder, which maintains the lookup and refresh latency histograms when
+// configured on the cache via cache.ConfigRecorder, and reads the counters and size of its Source,
+// usually that cache, at scrape time. Refresh passes include those made by the auto-refresh of the Resolver.
+//
+//	col := metrics.NewCollector("", "default")
+//	c, _ := cache.NewSimple(cache.NewConfigOption(cache.ConfigRecorder, col))
+//	col.SetSource(c)
+//	prometheus.MustRegister(col)
+//
+// The package is a module of its own, so that only its importers depend on Prometheus.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultNamespace is the prefix of every metric name, if NewCollector is passed an empty namespace.
+	DefaultNamespace = "dnscache"
+
+	// LookupBuckets are the upper bounds, in seconds, of the lookup latency histogram buckets.
+	// Changes after a Collector is instantiated are ignored.
+	LookupBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// RefreshBuckets are the upper bounds, in seconds, of the refresh duration histogram buckets.
+	// Changes after a Collector is instantiated are ignored.
+	RefreshBuckets = []float64{.1, .5, 1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+)
+
+// Source is what a Collector reads the size and counters of a cache from.
+// Every cache in the cache package satisfies it.
+type Source interface {
+	Len() int
+	Stats() cache.Stats
+}
+
+// Collector is a prometheus.Collector of the metrics of the cache of one named Resolver.
+// It is a goro-safe cache.Recorder.
+type Collector struct {
+	name   string
+	lock   sync.RWMutex
+	source Source
+
+	entries    *prometheus.Desc
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	lookupsN   *prometheus.Desc
+	errors     *prometheus.Desc
+	evictions  *prometheus.Desc
+	prefetches *prometheus.Desc
+	refreshesN *prometheus.Desc
+
+	lookups   *prometheus.HistogramVec
+	refreshes *prometheus.HistogramVec
+}
+
+// NewCollector returns a Collector for the named Resolver, whose metric names are prefixed with the
+// namespace, or DefaultNamespace if it is empty.
+func NewCollector(namespace, name string) *Collector {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	labels := prometheus.Labels{"resolver": name}
+	desc := func(metric, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", metric), help, variable, labels)
+	}
+
+	return &Collector{
+		name:       name,
+		entries:    desc("entries", "Number of entries in the cache."),
+		hits:       desc("hits_total", "Fetches served from the cache, by kind of entry.", "kind"),
+		misses:     desc("misses_total", "Fetches that required a live lookup."),
+		lookupsN:   desc("lookups_total", "Live lookups, including those made by refreshes."),
+		errors:     desc("lookup_errors_total", "Live lookups that failed."),
+		evictions:  desc("evictions_total", "Entries removed by the cache itself, due to size or age."),
+		prefetches: desc("prefetches_total", "Background lookups of entries nearing expiry."),
+		refreshesN: desc("refreshes_total", "Refresh passes, by outcome.", "outcome"),
+		lookups: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "lookup_duration_seconds",
+			Help:        "Latency of live lookups, by result.",
+			ConstLabels: labels,
+			Buckets:     LookupBuckets,
+		}, []string{"result"}),
+		refreshes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "refresh_duration_seconds",
+			Help:        "Duration of refresh passes, by outcome.",
+			ConstLabels: labels,
+			Buckets:     RefreshBuckets,
+		}, []string{"outcome"}),
+	}
+}
+
+// Name returns the name of the Collector, which is the value of its "resolver" label.
+func (c *Collector) Name() string {
+	return c.name
+}
+
+// SetSource sets the Source of the size and counter metrics. A nil Source omits them.
+func (c *Collector) SetSource(source Source) {
+	c.lock.Lock()
+	c.source = source
+	c.lock.Unlock()
+}
+
+// getSource returns the current Source, or nil.
+func (c *Collector) getSource() Source {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.source
+}
+
+// RecordLookup records a live lookup in the lookup latency histogram.
+func (c *Collector) RecordLookup(took time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	c.lookups.WithLabelValues(result).Observe(took.Seconds())
+}
+
+// RecordRefresh records a Refresh pass in the refresh duration histogram.
+func (c *Collector) RecordRefresh(took time.Duration, completed bool) {
+	outcome := "completed"
+	if !completed {
+		outcome = "timedout"
+	}
+	c.refreshes.WithLabelValues(outcome).Observe(took.Seconds())
+}
+
+// Describe sends the descriptors of the metrics of the Collector. It is part of prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.entries, c.hits, c.misses, c.lookupsN, c.errors, c.evictions, c.prefetches, c.refreshesN,
+	} {
+		ch <- d
+	}
+	c.lookups.Describe(ch)
+	c.refreshes.Describe(ch)
+}
+
+// Collect sends the metrics of the Collector, reading the Source, if any. It is part of prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if s := c.getSource(); s != nil {
+		stats := s.Stats()
+		gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+		}
+		counter := func(d *prometheus.Desc, v uint64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+		}
+
+		gauge(c.entries, float64(s.Len()))
+		counter(c.hits, stats.Hits, "fresh")
+		counter(c.hits, stats.StaleHits, "stale")
+		counter(c.hits, stats.NegativeHits, "negative")
+		counter(c.misses, stats.Misses)
+		counter(c.lookupsN, stats.Lookups)
+		counter(c.errors, stats.LookupErrors)
+		counter(c.evictions, stats.Evictions)
+		counter(c.prefetches, stats.Prefetches)
+		counter(c.refreshesN, stats.RefreshesCompleted, "completed")
+		counter(c.refreshesN, stats.RefreshesTimedOut, "timedout")
+	}
+	c.lookups.Collect(ch)
+	c.refreshes.Collect(ch)
+}
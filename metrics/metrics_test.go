@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flakyResolver returns a ResolverContextFunc that answers 8.8.8.8, or fails if fail is true.
+func flakyResolver(fail *atomic.Bool) cache.ResolverContextFunc {
+	return func(ctx context.Context, address string) ([]net.IP, error) {
+		if fail.Load() {
+			return nil, errors.New("boom")
+		}
+		return []net.IP{net.ParseIP("8.8.8.8")}, nil
+	}
+}
+
+// gathered returns the metric of the family named, with the labels, or nil.
+func gathered(families []*dto.MetricFamily, name string, labels map[string]string) *dto.Metric {
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func TestCollector(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a cache is recorded and registered, its metrics are gathered", t, func() {
+		var fail atomic.Bool
+		col := NewCollector("", "default")
+		So(col.Name(), ShouldEqual, "default")
+
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, flakyResolver(&fail)),
+			cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0)),
+			cache.NewConfigOption(cache.ConfigRecorder, col),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+		col.SetSource(c)
+
+		reg := prometheus.NewPedanticRegistry()
+		So(reg.Register(col), ShouldBeNil)
+
+		c.Fetch("dns.google.com") // miss
+		c.Fetch("dns.google.com") // hit
+		fail.Store(true)
+		c.Fetch("invalid.viki.io") // miss, error
+		c.Refresh(0)               // 1 lookup, 1 error
+
+		families, err := reg.Gather()
+		So(err, ShouldBeNil)
+
+		So(gathered(families, "dnscache_entries", nil).GetGauge().GetValue(), ShouldEqual, 1)
+		So(gathered(families, "dnscache_hits_total", map[string]string{"kind": "fresh"}).GetCounter().GetValue(), ShouldEqual, 1)
+		So(gathered(families, "dnscache_misses_total", nil).GetCounter().GetValue(), ShouldEqual, 2)
+		So(gathered(families, "dnscache_lookups_total", nil).GetCounter().GetValue(), ShouldEqual, 3)
+		So(gathered(families, "dnscache_lookup_errors_total", nil).GetCounter().GetValue(), ShouldEqual, 2)
+		So(gathered(families, "dnscache_refreshes_total", map[string]string{"outcome": "completed"}).GetCounter().GetValue(), ShouldEqual, 1)
+		So(gathered(families, "dnscache_lookup_duration_seconds", map[string]string{"result": "success"}).GetHistogram().GetSampleCount(), ShouldEqual, 1)
+		So(gathered(families, "dnscache_lookup_duration_seconds", map[string]string{"result": "error"}).GetHistogram().GetSampleCount(), ShouldEqual, 2)
+		So(gathered(families, "dnscache_refresh_duration_seconds", map[string]string{"outcome": "completed"}).GetHistogram().GetSampleCount(), ShouldEqual, 1)
+
+		for _, l := range gathered(families, "dnscache_entries", nil).GetLabel() {
+			So(l.GetName(), ShouldEqual, "resolver")
+			So(l.GetValue(), ShouldEqual, "default")
+		}
+
+		Convey("... alongside those of other Resolvers", func() {
+			So(reg.Register(NewCollector("", "other")), ShouldBeNil)
+			_, err := reg.Gather()
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("When a Collector has a namespace, metric names are prefixed with it", t, func() {
+		col := NewCollector("myapp", "default")
+		col.RecordLookup(time.Millisecond, nil)
+
+		reg := prometheus.NewPedanticRegistry()
+		So(reg.Register(col), ShouldBeNil)
+		families, err := reg.Gather()
+		So(err, ShouldBeNil)
+
+		So(gathered(families, "myapp_lookup_duration_seconds", map[string]string{"result": "success"}).GetHistogram().GetSampleCount(), ShouldEqual, 1)
+		So(gathered(families, "myapp_entries", nil), ShouldBeNil) // no Source
+	})
+}
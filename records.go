@@ -0,0 +1,177 @@
+package dnscache
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+)
+
+// recordCache is what records needs of each of its caches, whatever the type of their values.
+type recordCache interface {
+	Len() int
+	Purge()
+	RefreshResult(timeout time.Duration) (cache.RefreshResult, error)
+	Close() error
+}
+
+// records are the caches of the records other than addresses, for the Lookup functions named for them.
+// Each is instantiated on first use, so record types that are never looked up cost nothing.
+type records struct {
+	options []cache.ConfigOption // validated by newRecords
+
+	lock    sync.Mutex    // held while a cache is instantiated
+	created []recordCache // the caches instantiated so far
+	closed  bool          // caches instantiated after close are closed at once
+
+	srv   lazyCache[[]*net.SRV]
+	txt   lazyCache[[]string]
+	mx    lazyCache[[]*net.MX]
+	cname lazyCache[string]
+	addr  lazyCache[[]string]
+}
+
+// lazyCache is a cache.Cache of the resolver, instantiated by records on first use.
+type lazyCache[V any] struct {
+	resolver cache.ValueResolverFunc[V]
+	cache    atomic.Pointer[cache.Cache[V]]
+}
+
+// newRecords returns records whose caches use the resolvers of the config, or the cache.Default*Resolvers,
+// and its RecordOptions after the defaults. The options are validated now, by instantiating a cache with
+// them, rather than on first use. If they are invalid, the error is logged, and only the defaults used.
+func newRecords(config *ResolverConfig, logger *slog.Logger) *records {
+	defaults := []cache.ConfigOption{
+		cache.NewConfigOption(cache.ConfigRefreshSleepTime, RefreshSleepTime),
+		cache.NewConfigOption(cache.ConfigRefreshShuffle, RefreshShuffle),
+		cache.NewConfigOption(cache.ConfigLogger, logger),
+	}
+	r := &records{
+		options: defaults,
+	}
+	if len(config.RecordOptions) > 0 {
+		options := append(slices.Clone(defaults), config.RecordOptions...)
+		if c, err := cache.NewCache(cache.DefaultTXTResolver, options...); err != nil {
+			logger.Error("invalid RecordOptions, using the defaults", "error", err)
+		} else {
+			c.Close()
+			r.options = options
+		}
+	}
+
+	r.srv.resolver = orDefault(config.SRVResolver, cache.DefaultSRVResolver)
+	r.txt.resolver = orDefault(config.TXTResolver, cache.DefaultTXTResolver)
+	r.mx.resolver = orDefault(config.MXResolver, cache.DefaultMXResolver)
+	r.cname.resolver = orDefault(config.CNAMEResolver, cache.DefaultCNAMEResolver)
+	r.addr.resolver = orDefault(config.AddrResolver, cache.DefaultAddrResolver)
+	return r
+}
+
+// orDefault returns the resolver, or the default if it is nil.
+func orDefault[V any](resolver, def cache.ValueResolverFunc[V]) cache.ValueResolverFunc[V] {
+	if resolver == nil {
+		return def
+	}
+	return resolver
+}
+
+// get returns the cache, instantiating it with the options of the records if it has not been.
+func (l *lazyCache[V]) get(r *records) *cache.Cache[V] {
+	if c := l.cache.Load(); c != nil {
+		return c
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c := l.cache.Load(); c != nil {
+		return c
+	}
+	c, _ := cache.NewCache(l.resolver, r.options...) // validated, no error trap needed
+	if r.closed {
+		c.Close()
+	}
+	r.created = append(r.created, c)
+	l.cache.Store(c)
+	return c
+}
+
+// all returns the caches instantiated so far.
+func (r *records) all() []recordCache {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.created)
+}
+
+// refresh refreshes the caches that have entries, sharing the time left until the deadline, if it is
+// not zero. Caches not reached by the deadline are left for the next pass. Failures are logged by the caches.
+func (r *records) refresh(deadline time.Time) {
+	for _, c := range r.all() {
+		var timeout time.Duration
+		if !deadline.IsZero() {
+			if timeout = time.Until(deadline); timeout <= 0 {
+				return
+			}
+		}
+		if c.Len() > 0 {
+			c.RefreshResult(timeout)
+		}
+	}
+}
+
+// purge removes all entries from the caches.
+func (r *records) purge() {
+	for _, c := range r.all() {
+		c.Purge()
+	}
+}
+
+// close closes the caches, and any instantiated hereafter.
+func (r *records) close() {
+	r.lock.Lock()
+	r.closed = true
+	r.lock.Unlock()
+
+	for _, c := range r.all() {
+		c.Close()
+	}
+}
+
+// LookupSRV returns the SRV records of the service, from cache, or a live lookup if not.
+// As with net.Resolver.LookupSRV, the name looked up is "_service._proto.name", or just the name
+// if service and proto are both empty. The Context is honored by the live lookup, if any.
+// The records returned are shared, and must not be modified.
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	return r.records.srv.get(r.records).FetchContext(ctx, name)
+}
+
+// LookupTXT returns the TXT records of the name, from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any. The records returned are shared, and must not be modified.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.records.txt.get(r.records).FetchContext(ctx, name)
+}
+
+// LookupMX returns the MX records of the name, sorted by preference, from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any. The records returned are shared, and must not be modified.
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return r.records.mx.get(r.records).FetchContext(ctx, name)
+}
+
+// LookupCNAME returns the canonical name of the name, from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any.
+func (r *Resolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	return r.records.cname.get(r.records).FetchContext(ctx, name)
+}
+
+// LookupAddr returns the names of the address, via its PTR records, from cache, or a live lookup if not.
+// The Context is honored by the live lookup, if any. The names returned are shared, and must not be modified.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.records.addr.get(r.records).FetchContext(ctx, addr)
+}
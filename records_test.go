@@ -0,0 +1,151 @@
+package dnscache
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// withFakeRecordResolvers sets record resolvers of the config that answer from the name, counting
+// their lookups, and returns the config.
+func withFakeRecordResolvers(config *ResolverConfig, calls *atomic.Int32) *ResolverConfig {
+	config.SRVResolver = func(_ context.Context, name string) ([]*net.SRV, time.Duration, error) {
+		calls.Add(1)
+		return []*net.SRV{{Target: "srv." + name, Port: 443}}, cache.TTLUnknown, nil
+	}
+	config.TXTResolver = func(_ context.Context, name string) ([]string, time.Duration, error) {
+		calls.Add(1)
+		return []string{"flag=" + name}, cache.TTLUnknown, nil
+	}
+	config.MXResolver = func(_ context.Context, name string) ([]*net.MX, time.Duration, error) {
+		calls.Add(1)
+		return []*net.MX{{Host: "mx." + name, Pref: 10}}, cache.TTLUnknown, nil
+	}
+	config.CNAMEResolver = func(_ context.Context, name string) (string, time.Duration, error) {
+		calls.Add(1)
+		return "canonical." + name, cache.TTLUnknown, nil
+	}
+	config.AddrResolver = func(_ context.Context, addr string) ([]string, time.Duration, error) {
+		calls.Add(1)
+		if addr == "192.0.2.99" {
+			return nil, cache.TTLUnknown, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		}
+		return []string{"host.test."}, cache.TTLUnknown, nil
+	}
+	return config
+}
+
+func TestLookupRecords(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When records other than addresses are looked up, they are cached", t, func() {
+		var calls atomic.Int32
+		r := NewFromConfig(withFakeRecordResolvers(&ResolverConfig{
+			RecordOptions: []cache.ConfigOption{cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0))},
+		}, &calls))
+		defer r.Close()
+
+		for range 2 {
+			srvs, err := r.LookupSRV(context.Background(), "http", "tcp", "a.test")
+			So(err, ShouldBeNil)
+			So(srvs, ShouldResemble, []*net.SRV{{Target: "srv._http._tcp.a.test", Port: 443}})
+
+			srvs, err = r.LookupSRV(context.Background(), "", "", "_ldap._tcp.a.test")
+			So(err, ShouldBeNil)
+			So(srvs[0].Target, ShouldEqual, "srv._ldap._tcp.a.test")
+
+			txts, err := r.LookupTXT(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+			So(txts, ShouldResemble, []string{"flag=a.test"})
+
+			mxs, err := r.LookupMX(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+			So(mxs, ShouldResemble, []*net.MX{{Host: "mx.a.test", Pref: 10}})
+
+			cname, err := r.LookupCNAME(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+			So(cname, ShouldEqual, "canonical.a.test")
+
+			names, err := r.LookupAddr(context.Background(), "192.0.2.1")
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"host.test."})
+		}
+		So(calls.Load(), ShouldEqual, 6)
+
+		_, err := r.LookupAddr(context.Background(), "192.0.2.99")
+		So(err, ShouldBeError)
+		So(calls.Load(), ShouldEqual, 7)
+
+		Convey("and refreshed with the cache, and purged with it", func() {
+			_, err := r.RefreshResult(0)
+			So(err, ShouldBeNil)
+			So(calls.Load(), ShouldEqual, 13)
+
+			r.Purge()
+			_, err = r.LookupTXT(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+			So(calls.Load(), ShouldEqual, 14)
+		})
+	})
+
+	Convey("When records are looked up, only the caches of their types are instantiated, even after Close", t, func() {
+		var calls atomic.Int32
+		r := NewFromConfig(withFakeRecordResolvers(&ResolverConfig{}, &calls))
+		So(r.records.all(), ShouldBeEmpty)
+
+		_, err := r.LookupTXT(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(r.records.all(), ShouldHaveLength, 1)
+
+		So(r.Close(), ShouldBeNil)
+		_, err = r.LookupMX(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(r.records.all(), ShouldHaveLength, 2)
+		_, err = r.records.mx.get(r.records).RefreshResult(0)
+		So(err, ShouldEqual, cache.ErrorClosed)
+	})
+
+	Convey("When records are refreshed with a timeout, the record caches share it", t, func() {
+		var calls atomic.Int32
+		r := NewFromConfig(withFakeRecordResolvers(&ResolverConfig{
+			RecordOptions: []cache.ConfigOption{cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Second)},
+		}, &calls))
+		defer r.Close()
+
+		for _, name := range []string{"a.test", "b.test"} {
+			r.LookupTXT(context.Background(), name)
+			r.LookupMX(context.Background(), name)
+			r.LookupCNAME(context.Background(), name)
+		}
+
+		start := time.Now()
+		r.RefreshResult(100 * time.Millisecond)
+		So(time.Since(start), ShouldBeLessThan, 250*time.Millisecond)
+	})
+
+	Convey("When the RecordOptions are invalid, it is logged at once, and the defaults are used", t, func() {
+		var (
+			calls atomic.Int32
+			buf   bytes.Buffer
+		)
+		r := NewFromConfig(withFakeRecordResolvers(&ResolverConfig{
+			RecordOptions: []cache.ConfigOption{cache.NewConfigOption(cache.ConfigRefreshSleepTime, "1s")},
+			Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+		}, &calls))
+		defer r.Close()
+		So(buf.String(), ShouldContainSubstring, `level=ERROR msg="invalid RecordOptions, using the defaults"`)
+		So(r.records.all(), ShouldBeEmpty)
+
+		txts, err := r.LookupTXT(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(txts, ShouldResemble, []string{"flag=a.test"})
+	})
+}
@@ -0,0 +1,114 @@
+package dnscache
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// SelectFirst is a Selection that always chooses the first IP. This is the default.
+	SelectFirst = Selection("First")
+	// SelectRoundRobin is a Selection that rotates through the IPs, per address.
+	SelectRoundRobin = Selection("RoundRobin")
+	// SelectRandom is a Selection that chooses an IP at random.
+	SelectRandom = Selection("Random")
+	// SelectWeightedRandom is a Selection that chooses an IP at random, in proportion
+	// to the weights returned by the ResolverConfig Weights function.
+	SelectWeightedRandom = Selection("WeightedRandom")
+)
+
+// Selection is a string type for static consistency
+type Selection string
+
+// supported returns true if the Selection is one of those defined, or empty.
+func (s Selection) supported() bool {
+	switch s {
+	case "", SelectFirst, SelectRoundRobin, SelectRandom, SelectWeightedRandom:
+		return true
+	}
+	return false
+}
+
+// WeightFunc returns the relative weight of the IP for the address, for SelectWeightedRandom.
+// IPs with weights <= 0 are never chosen, unless all of them are.
+type WeightFunc func(address string, ip net.IP) int
+
+// selector is a goro-safe chooser of one IP from many, according to a Selection.
+type selector struct {
+	selection Selection
+	weights   WeightFunc
+	counters  sync.Map // address -> *atomic.Uint64, for SelectRoundRobin
+}
+
+// newSelector returns a selector for the Selection. An empty Selection is SelectFirst.
+// A nil WeightFunc weighs every IP equally.
+func newSelector(selection Selection, weights WeightFunc) *selector {
+	if selection == "" {
+		selection = SelectFirst
+	}
+	return &selector{
+		selection: selection,
+		weights:   weights,
+	}
+}
+
+// pick returns one of the IPs for the address, or nil if there are none.
+func (s *selector) pick(address string, ips []net.IP) net.IP {
+	switch len(ips) {
+	case 0:
+		return nil
+	case 1:
+		return ips[0]
+	}
+
+	switch s.selection {
+	case SelectRoundRobin:
+		c, _ := s.counters.LoadOrStore(address, &atomic.Uint64{})
+		n := c.(*atomic.Uint64).Add(1) - 1
+		return ips[n%uint64(len(ips))]
+	case SelectRandom:
+		return ips[rand.IntN(len(ips))]
+	case SelectWeightedRandom:
+		return ips[s.weighted(address, ips)]
+	default:
+		return ips[0]
+	}
+}
+
+// weighted returns the index of an IP chosen in proportion to its weight.
+func (s *selector) weighted(address string, ips []net.IP) int {
+	if s.weights == nil {
+		return rand.IntN(len(ips))
+	}
+
+	var (
+		total   int
+		weights = make([]int, len(ips))
+	)
+	for i, ip := range ips {
+		if w := s.weights(address, ip); w > 0 {
+			weights[i] = w
+			total += w
+		}
+	}
+	if total == 0 {
+		// nobody has any weight, so everybody does
+		return rand.IntN(len(ips))
+	}
+
+	n := rand.IntN(total)
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(ips) - 1 // unreachable
+}
+
+// forget drops any per-address state, for all addresses.
+func (s *selector) forget() {
+	s.counters.Clear()
+}
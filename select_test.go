@@ -0,0 +1,139 @@
+package dnscache
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSelection(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	ips := stringsToIPs("1.1.2.3", "1.1.2.4", "1.1.2.5")
+
+	Convey("When a Resolver is created with SelectRoundRobin, FetchOne rotates through the IPs.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:     c,
+			Selection: SelectRoundRobin,
+		})
+		defer r.Close()
+		c.Add("something.viki.io", ips)
+		c.Add("another.viki.io", ips)
+
+		for i := range 6 {
+			ip, _ := r.FetchOne("something.viki.io")
+			So(ip, ShouldResemble, ips[i%3])
+		}
+		ip, _ := r.FetchOne("another.viki.io")
+		So(ip, ShouldResemble, ips[0])
+
+		Convey("and concurrent callers share the rotation evenly", func() {
+			var (
+				wg     sync.WaitGroup
+				lock   sync.Mutex
+				counts = make(map[string]int)
+			)
+			for range 300 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ip, _ := r.FetchOneString("something.viki.io")
+					lock.Lock()
+					counts[ip]++
+					lock.Unlock()
+				}()
+			}
+			wg.Wait()
+			So(counts, ShouldResemble, map[string]int{"1.1.2.3": 100, "1.1.2.4": 100, "1.1.2.5": 100})
+		})
+	})
+
+	Convey("When a Resolver is created with SelectRandom, FetchOne uses all of the IPs.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:     c,
+			Selection: SelectRandom,
+		})
+		defer r.Close()
+		c.Add("something.viki.io", ips)
+
+		seen := make(map[string]bool)
+		for range 300 {
+			ip, _ := r.FetchOneString("something.viki.io")
+			seen[ip] = true
+		}
+		So(seen, ShouldHaveLength, 3)
+	})
+
+	Convey("When a Resolver is created with SelectWeightedRandom, FetchOne honors the weights.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:     c,
+			Selection: SelectWeightedRandom,
+			Weights: func(address string, ip net.IP) int {
+				if ip.Equal(ips[1]) {
+					return 0
+				}
+				return 1
+			},
+		})
+		defer r.Close()
+		c.Add("something.viki.io", ips)
+
+		seen := make(map[string]bool)
+		for range 300 {
+			ip, _ := r.FetchOneString("something.viki.io")
+			seen[ip] = true
+		}
+		So(seen, ShouldResemble, map[string]bool{"1.1.2.3": true, "1.1.2.5": true})
+	})
+
+	Convey("When a Resolver is created without a Selection, FetchOne returns the first IP.", t, func() {
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache: c,
+		})
+		defer r.Close()
+		c.Add("something.viki.io", ips)
+
+		for range 10 {
+			ip, _ := r.FetchOne("something.viki.io")
+			So(ip, ShouldResemble, ips[0])
+		}
+	})
+
+	Convey("When a Resolver is created with an unsupported Selection, it is logged, and FetchOne returns the first IP.", t, func() {
+		var buf bytes.Buffer
+		c, err := cache.NewSimple()
+		So(err, ShouldBeNil)
+
+		r := NewFromConfig(&ResolverConfig{
+			Cache:     c,
+			Selection: Selection("Roundrobin"),
+			Logger:    slog.New(slog.NewTextHandler(&buf, nil)),
+		})
+		defer r.Close()
+		c.Add("something.viki.io", ips)
+
+		So(buf.String(), ShouldContainSubstring, `level=ERROR msg="unsupported Selection, using SelectFirst" selection=Roundrobin`)
+		for range 10 {
+			ip, _ := r.FetchOne("something.viki.io")
+			So(ip, ShouldResemble, ips[0])
+		}
+	})
+}
@@ -0,0 +1,414 @@
+// Package upstream provides DNS clients that speak the wire protocol to configured nameservers,
+// for use as the resolver of dnscache caches, in place of the system resolver.
+//
+// Unlike net.LookupIP, a Client chooses which nameservers are asked, and returns the TTL of
+// the answers, so caches may expire entries as the records do:
+//
+//	client, _ := upstream.New(&upstream.Config{Servers: []string{"10.0.0.53", "10.0.1.53"}})
+//	c, _ := cache.NewSimple(
+//		cache.NewConfigOption(cache.ConfigResolver, client.Resolver()),
+//		cache.NewConfigOption(cache.ConfigRecordTTL, true),
+//	)
+//
+// By default, queries are sent over UDP, falling back to TCP when an answer is truncated.
+// Where only encrypted DNS is permitted, DNS-over-TLS (RFC 7858) and DNS-over-HTTPS (RFC 8484)
+// may be used instead, via the Protocol:
+//
+//	client, _ := upstream.New(&upstream.Config{
+//		Protocol: upstream.ProtocolHTTPS,
+//		Servers:  []string{"https://dns.example/dns-query"},
+//	})
+//	defer client.Close()
+//
+// Names are looked up as given, fully-qualified: there is no search list.
+//
+// A Group combines several resolvers, such as Clients of different providers, so that one outage
+// does not fail every cache miss. It asks them by failover, race, or round-robin, tracking their health,
+// and passing over those that keep failing:
+//
+//	g, _ := upstream.NewGroup(&upstream.GroupConfig{
+//		Upstreams: []upstream.Upstream{
+//			{Name: "primary", Resolver: primary.Resolver()},
+//			{Name: "secondary", Resolver: secondary.Resolver()},
+//		},
+//	})
+//	c, _ := cache.NewSimple(cache.NewConfigOption(cache.ConfigResolver, g.Resolver()))
+package upstream
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+)
+
+var (
+	// ErrorNoServers is returned by New if no Servers are configured.
+	ErrorNoServers = errors.New("no servers are configured")
+	// ErrorProtocolUnsupported is returned by New if the Protocol is not one of those defined.
+	ErrorProtocolUnsupported = errors.New("protocol is not supported")
+
+	// DefaultTimeout is the Timeout used if none is configured.
+	DefaultTimeout = 2 * time.Second
+	// DefaultUDPSize is the UDPSize used if none is configured. It avoids IP fragmentation on most paths.
+	DefaultUDPSize = 1232
+	// DefaultIdleTimeout is the IdleTimeout used if none is configured.
+	DefaultIdleTimeout = 10 * time.Second
+)
+
+const (
+	// ProtocolUDP is a Protocol for queries over UDP, retried over TCP if the answer is truncated.
+	// Servers are a host or host:port, with the port defaulting to 53.
+	ProtocolUDP = Protocol("UDP")
+	// ProtocolTLS is a Protocol for DNS-over-TLS. Each server's connection is kept open, for IdleTimeout,
+	// and queries are pipelined over it. Servers are a host or host:port, with the port defaulting to 853.
+	ProtocolTLS = Protocol("TLS")
+	// ProtocolHTTPS is a Protocol for DNS-over-HTTPS. Servers are the https URLs of their DNS API,
+	// e.g. https://dns.example/dns-query.
+	ProtocolHTTPS = Protocol("HTTPS")
+)
+
+const (
+	minUDPSize = 512 // the largest UDP message every server may send
+	maxCNAMEs  = 10  // CNAMEs followed per answer, before giving up
+)
+
+// Protocol is a string type for static consistency
+type Protocol string
+
+// Config is the configuration of a Client.
+type Config struct {
+	// Protocol is how the Servers are queried. Defaults to ProtocolUDP.
+	Protocol Protocol
+	// Servers are the nameservers to query, in order, in the form the Protocol requires.
+	Servers []string
+	// Timeout is how long each server is waited for, per attempt. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Retries is how many more times the Servers are tried, in order, after they all failed. Defaults to 0.
+	Retries int
+	// UDPSize is the UDP payload size advertised via EDNS0, and the largest UDP answer accepted.
+	// Defaults to DefaultUDPSize.
+	UDPSize int
+	// DisableEDNS0 omits the EDNS0 OPT record from queries, limiting UDP answers to 512 bytes.
+	DisableEDNS0 bool
+
+	// TLSConfig is the tls.Config of ProtocolTLS connections, and of ProtocolHTTPS ones unless HTTPClient
+	// is set. Its ServerName, if empty, is the host of each server. Defaults to the system roots.
+	TLSConfig *tls.Config
+	// IdleTimeout is how long a ProtocolTLS connection is kept open without queries in flight.
+	// Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// HTTPClient is the http.Client ProtocolHTTPS queries are made with. Defaults to one of its own,
+	// using TLSConfig.
+	HTTPClient *http.Client
+	// HTTPMethod is the method of ProtocolHTTPS queries: http.MethodGet, or http.MethodPost.
+	// Defaults to http.MethodPost.
+	HTTPMethod string
+}
+
+// Client is a DNS client, querying its servers in order until one answers. It is goro-safe.
+type Client struct {
+	servers   []string
+	timeout   time.Duration
+	retries   int
+	udpSize   int // 0 if EDNS0 is disabled
+	transport transport
+}
+
+// transport sends queries to servers in the manner of a Protocol.
+type transport interface {
+	// exchange sends the query to the server, setting its id, and returns the answer to it.
+	exchange(ctx context.Context, server string, query *message) (*message, error)
+	// close closes any connections kept open.
+	close()
+}
+
+// New returns a Client with the Config, or an error if it is invalid.
+func New(config *Config) (*Client, error) {
+	if len(config.Servers) == 0 {
+		return nil, ErrorNoServers
+	}
+	c := &Client{
+		timeout: config.Timeout,
+		retries: config.Retries,
+		udpSize: config.UDPSize,
+	}
+
+	var parse func(string) (string, error)
+	switch config.Protocol {
+	case ProtocolUDP, "":
+		parse = func(s string) (string, error) { return serverAddress(s, "53") }
+	case ProtocolTLS:
+		parse = func(s string) (string, error) { return serverAddress(s, "853") }
+	case ProtocolHTTPS:
+		parse = serverURL
+	default:
+		return nil, ErrorProtocolUnsupported
+	}
+	for _, s := range config.Servers {
+		server, err := parse(s)
+		if err != nil {
+			return nil, err
+		}
+		c.servers = append(c.servers, server)
+	}
+
+	switch {
+	case c.timeout < 0:
+		return nil, errors.New("Timeout must be >= 0")
+	case c.retries < 0:
+		return nil, errors.New("Retries must be >= 0")
+	case c.udpSize != 0 && (c.udpSize < minUDPSize || c.udpSize > 65535):
+		return nil, fmt.Errorf("UDPSize must be between %d and 65535", minUDPSize)
+	case config.IdleTimeout < 0:
+		return nil, errors.New("IdleTimeout must be >= 0")
+	case config.HTTPMethod != "" && config.HTTPMethod != http.MethodGet && config.HTTPMethod != http.MethodPost:
+		return nil, errors.New("HTTPMethod must be GET or POST")
+	}
+	if c.timeout == 0 {
+		c.timeout = DefaultTimeout
+	}
+	if c.udpSize == 0 {
+		c.udpSize = DefaultUDPSize
+	}
+	if config.DisableEDNS0 {
+		c.udpSize = 0
+	}
+
+	switch config.Protocol {
+	case ProtocolTLS:
+		c.transport = newTLSTransport(config.TLSConfig, cmp.Or(config.IdleTimeout, DefaultIdleTimeout))
+	case ProtocolHTTPS:
+		c.transport = newHTTPSTransport(config.HTTPClient, config.TLSConfig, cmp.Or(config.HTTPMethod, http.MethodPost))
+	default:
+		c.transport = &udpTransport{udpSize: c.udpSize}
+	}
+	return c, nil
+}
+
+// Close closes any connections the Client keeps open. Lookups in progress fail, but the Client
+// may still be used, opening new connections as needed.
+func (c *Client) Close() error {
+	c.transport.close()
+	return nil
+}
+
+// serverAddress returns the server as a host:port, with the port defaulted if absent.
+func serverAddress(server, port string) (string, error) {
+	if host, p, err := net.SplitHostPort(server); err == nil {
+		if host == "" || p == "" {
+			return "", fmt.Errorf("server %q is invalid", server)
+		}
+		return server, nil
+	}
+	if server == "" {
+		return "", fmt.Errorf("server %q is invalid", server)
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port), nil
+}
+
+// serverURL returns the server if it is an https URL.
+func serverURL(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("server %q is not an https URL", server)
+	}
+	return server, nil
+}
+
+// Resolver returns LookupIPTTL as a cache.ResolverTTLFunc, for ConfigResolver.
+func (c *Client) Resolver() cache.ResolverTTLFunc {
+	return c.LookupIPTTL
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of the host, as net.LookupIP does.
+// It is a cache.ResolverContextFunc.
+func (c *Client) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := c.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL is LookupIP, but also returns the lowest TTL of the records of the answer, including any
+// CNAMEs followed. It is a cache.ResolverTTLFunc.
+//
+// The A and AAAA queries are made concurrently. If either answers, its addresses are returned, IPv4 first.
+// Errors are *net.DNSErrors, with IsNotFound set if the host does not exist or has no addresses,
+// unless the Context is done, in which case its error is returned.
+// IP literals are returned as they are, with cache.TTLUnknown.
+func (c *Client) LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, cache.TTLUnknown, nil
+	}
+
+	type answer struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	var (
+		answers [2]answer
+		wg      sync.WaitGroup
+	)
+	for i, qtype := range []uint16{typeA, typeAAAA} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := &answers[i]
+			a.ips, a.ttl, a.err = c.lookup(ctx, host, qtype)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		ips []net.IP
+		ttl = cache.TTLUnknown
+		err error
+	)
+	for _, a := range answers {
+		if a.err != nil {
+			// the more interesting error wins: anything beats not found
+			var dnsErr *net.DNSError
+			if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+				err = a.err
+			}
+			continue
+		}
+		if len(a.ips) == 0 {
+			continue
+		}
+		ips = append(ips, a.ips...)
+		if ttl == cache.TTLUnknown || a.ttl < ttl {
+			ttl = a.ttl
+		}
+	}
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if err == nil {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return nil, cache.TTLUnknown, err
+}
+
+// lookup queries the servers for records of the type, returning their addresses and lowest TTL.
+// A name that exists without records of the type returns no addresses, and no error.
+func (c *Client) lookup(ctx context.Context, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	resp, server, err := c.exchange(ctx, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.rcode == rcodeNameError {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: server, IsNotFound: true}
+	}
+	ips, ttl := addresses(resp, fqdn(host), qtype)
+	return ips, ttl, nil
+}
+
+// addresses returns the addresses of the type for the name in the answers of the message, following
+// any CNAMEs, and the lowest TTL of the records involved.
+func addresses(m *message, name string, qtype uint16) ([]net.IP, time.Duration) {
+	var (
+		names  = []string{name}
+		lowest = uint32(0)
+		seen   bool
+	)
+	lower := func(ttl uint32) {
+		if !seen || ttl < lowest {
+			lowest, seen = ttl, true
+		}
+	}
+
+	// follow the CNAME chain, in whatever order the records are
+	for range maxCNAMEs {
+		i := slices.IndexFunc(m.answers, func(r record) bool {
+			return r.rtype == typeCNAME && strings.EqualFold(r.name, names[len(names)-1])
+		})
+		if i < 0 {
+			break
+		}
+		names = append(names, m.answers[i].target)
+		lower(m.answers[i].ttl)
+	}
+
+	var ips []net.IP
+	for _, r := range m.answers {
+		if r.rtype != qtype || r.class != classINET {
+			continue
+		}
+		if slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, r.name) }) {
+			ips = append(ips, r.ip)
+			lower(r.ttl)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, cache.TTLUnknown
+	}
+	return ips, time.Duration(lowest) * time.Second
+}
+
+// exchange sends the query to the servers in order, for as many rounds as are allowed, until one answers
+// authoritatively, returning the answer and the server that sent it. Servers that fail, or answer with
+// an error other than a name error, are passed over.
+func (c *Client) exchange(ctx context.Context, host string, qtype uint16) (*message, string, error) {
+	var err error
+	for range c.retries + 1 {
+		for _, server := range c.servers {
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+
+			var resp *message
+			if resp, err = c.exchangeServer(ctx, server, host, qtype); err != nil {
+				continue
+			}
+			if resp.rcode == rcodeSuccess || resp.rcode == rcodeNameError {
+				return resp, server, nil
+			}
+			err = &net.DNSError{Err: "server misbehaving", Name: host, Server: server, IsTemporary: true}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	return nil, "", err
+}
+
+// exchangeServer sends the query to the server via the transport.
+func (c *Client) exchangeServer(ctx context.Context, server, host string, qtype uint16) (*message, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := c.transport.exchange(ctx, server, newQuery(0, host, qtype, c.udpSize))
+	if err != nil {
+		return nil, netError(err, host, server)
+	}
+	return resp, nil
+}
+
+// answers returns true if the response is an answer to the query.
+func answers(query, resp *message) bool {
+	if !resp.response || resp.id != query.id || len(resp.questions) != 1 {
+		return false
+	}
+	q, r := query.questions[0], resp.questions[0]
+	return r.qtype == q.qtype && r.class == q.class && strings.EqualFold(r.name, q.name)
+}
+
+// netError returns the error of an exchange with the server as a *net.DNSError.
+func netError(err error, host, server string) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &net.DNSError{Err: "i/o timeout", Name: host, Server: server, IsTimeout: true, IsTemporary: true}
+	}
+	return &net.DNSError{Err: err.Error(), Name: host, Server: server, IsTemporary: true}
+}
@@ -0,0 +1,245 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testZone is answered by most of the tests.
+var testZone = zone{
+	"a.test.":     {a("a.test.", "192.0.2.1", 60), a("a.test.", "192.0.2.2", 30)},
+	"both.test.":  {a("both.test.", "192.0.2.3", 300), aaaa("both.test.", "2001:db8::3", 120)},
+	"alias.test.": {cname("alias.test.", "CNAME.test.", 20)},
+	"cname.test.": {cname("cname.test.", "a.test.", 40)},
+	"empty.test.": {},
+}
+
+// newTestClient returns a Client of the servers, with a short Timeout.
+func newTestClient(config Config, servers ...*fakeServer) *Client {
+	for _, s := range servers {
+		config.Servers = append(config.Servers, s.addr())
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 50 * time.Millisecond
+	}
+	c, err := New(&config)
+	So(err, ShouldBeNil)
+	return c
+}
+
+func TestNew(t *testing.T) {
+	Convey("When a Client is configured, the defaults are applied, and invalid values refused", t, func() {
+		c, err := New(&Config{Servers: []string{"192.0.2.53", "[2001:db8::53]", "192.0.2.54:5353"}})
+		So(err, ShouldBeNil)
+		So(c.servers, ShouldResemble, []string{"192.0.2.53:53", "[2001:db8::53]:53", "192.0.2.54:5353"})
+		So(c.timeout, ShouldEqual, DefaultTimeout)
+		So(c.udpSize, ShouldEqual, DefaultUDPSize)
+
+		c, err = New(&Config{Protocol: ProtocolTLS, Servers: []string{"192.0.2.53", "192.0.2.54:8853"}})
+		So(err, ShouldBeNil)
+		So(c.servers, ShouldResemble, []string{"192.0.2.53:853", "192.0.2.54:8853"})
+
+		c, err = New(&Config{Servers: []string{"192.0.2.53"}, DisableEDNS0: true})
+		So(err, ShouldBeNil)
+		So(c.udpSize, ShouldBeZeroValue)
+
+		_, err = New(&Config{})
+		So(err, ShouldEqual, ErrorNoServers)
+		_, err = New(&Config{Servers: []string{""}})
+		So(err, ShouldBeError)
+		_, err = New(&Config{Servers: []string{"192.0.2.53"}, Timeout: -1})
+		So(err, ShouldBeError)
+		_, err = New(&Config{Servers: []string{"192.0.2.53"}, Retries: -1})
+		So(err, ShouldBeError)
+		_, err = New(&Config{Servers: []string{"192.0.2.53"}, UDPSize: 100})
+		So(err, ShouldBeError)
+	})
+}
+
+func TestLookupIPTTL(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When addresses are looked up, the records are returned, with the lowest TTL", t, func() {
+		s := newZoneServer(testZone)
+		defer s.close()
+		c := newTestClient(Config{}, s)
+
+		ips, ttl, err := c.LookupIPTTL(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")})
+		So(ttl, ShouldEqual, 30*time.Second)
+
+		ips, ttl, err = c.LookupIPTTL(context.Background(), "both.test.")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.3"), net.ParseIP("2001:db8::3")})
+		So(ttl, ShouldEqual, 120*time.Second)
+
+		ips, err = c.LookupIP(context.Background(), "192.0.2.9")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.9")})
+	})
+
+	Convey("When a name is an alias, the CNAME chain is followed, and its TTLs count", t, func() {
+		s := newZoneServer(testZone)
+		defer s.close()
+		c := newTestClient(Config{}, s)
+
+		ips, ttl, err := c.LookupIPTTL(context.Background(), "alias.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")})
+		So(ttl, ShouldEqual, 20*time.Second)
+	})
+
+	Convey("When a name does not exist, or has no addresses, a not found DNSError is returned", t, func() {
+		s := newZoneServer(testZone)
+		defer s.close()
+		c := newTestClient(Config{}, s)
+
+		for _, name := range []string{"missing.test", "empty.test"} {
+			ips, ttl, err := c.LookupIPTTL(context.Background(), name)
+			So(ips, ShouldBeEmpty)
+			So(ttl, ShouldEqual, cache.TTLUnknown)
+			So(isDNSError(err), ShouldNotBeNil)
+			So(isDNSError(err).IsNotFound, ShouldBeTrue)
+		}
+	})
+
+	Convey("When the Context is cancelled, its error is returned", t, func() {
+		s := newFakeServer(func(*message, bool) *message { return nil })
+		defer s.close()
+		c := newTestClient(Config{Timeout: time.Minute}, s)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, _, err := c.LookupIPTTL(ctx, "a.test")
+		So(err, ShouldEqual, context.Canceled)
+	})
+}
+
+func TestExchange(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When queries are sent, they advertise the EDNS0 UDP size, unless it is disabled", t, func() {
+		s := newZoneServer(testZone)
+		defer s.close()
+
+		c := newTestClient(Config{UDPSize: 4096}, s)
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		q := s.lastQuery()
+		So(q.recursionDesired, ShouldBeTrue)
+		So(q.additionals, ShouldHaveLength, 1)
+		So(q.additionals[0].rtype, ShouldEqual, typeOPT)
+		So(q.additionals[0].class, ShouldEqual, 4096)
+
+		c = newTestClient(Config{DisableEDNS0: true}, s)
+		_, err = c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(s.lastQuery().additionals, ShouldBeEmpty)
+	})
+
+	Convey("When an answer is truncated, the query is retried over TCP", t, func() {
+		big := zone{"big.test.": {}}
+		for i := range 100 {
+			big["big.test."] = append(big["big.test."], a("big.test.", fmt.Sprintf("192.0.2.%d", i), 60))
+		}
+		s := newZoneServer(big)
+		defer s.close()
+
+		c := newTestClient(Config{}, s)
+		ips, err := c.LookupIP(context.Background(), "big.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 100)
+		So(s.tcpQueries.Load(), ShouldEqual, 1) // the A answer, not the empty AAAA one
+	})
+
+	Convey("When a server does not answer, or fails, the next is asked", t, func() {
+		silent := newFakeServer(func(*message, bool) *message { return nil })
+		defer silent.close()
+		failing := newFakeServer(func(*message, bool) *message {
+			return &message{header: header{rcode: 2}} // SERVFAIL
+		})
+		defer failing.close()
+		s := newZoneServer(testZone)
+		defer s.close()
+
+		c := newTestClient(Config{}, silent, failing, s)
+		ips, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 2)
+		So(silent.udpQueries.Load(), ShouldEqual, 2) // A and AAAA
+		So(failing.udpQueries.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When a server answers with a name error, the next is not asked", t, func() {
+		first := newZoneServer(zone{})
+		defer first.close()
+		second := newZoneServer(testZone)
+		defer second.close()
+
+		c := newTestClient(Config{}, first, second)
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(isDNSError(err).IsNotFound, ShouldBeTrue)
+		So(second.udpQueries.Load(), ShouldBeZeroValue)
+	})
+
+	Convey("When every server fails, the servers are retried, and the last error returned", t, func() {
+		silent := newFakeServer(func(*message, bool) *message { return nil })
+		defer silent.close()
+
+		c := newTestClient(Config{Retries: 2}, silent)
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(isDNSError(err), ShouldNotBeNil)
+		So(isDNSError(err).IsTimeout, ShouldBeTrue)
+		So(isDNSError(err).IsNotFound, ShouldBeFalse)
+		So(silent.udpQueries.Load(), ShouldEqual, 6) // 3 attempts, A and AAAA
+	})
+
+	Convey("When an answer is for a different query, it is ignored", t, func() {
+		s := newFakeServer(func(q *message, _ bool) *message {
+			q.questions[0].name = "other.test."
+			return &message{}
+		})
+		defer s.close()
+
+		c := newTestClient(Config{}, s)
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(isDNSError(err).IsTimeout, ShouldBeTrue)
+	})
+}
+
+func TestClientAsCacheResolver(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Client is the resolver of a cache, the cache records the TTLs of its answers", t, func() {
+		s := newZoneServer(testZone)
+		defer s.close()
+		client := newTestClient(Config{}, s)
+
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, client.Resolver()),
+			cache.NewConfigOption(cache.ConfigRecordTTL, true),
+		)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		before := time.Now()
+		ips, err := c.Fetch("cname.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 2)
+
+		e, ok := c.GetEntry("cname.test")
+		So(ok, ShouldBeTrue)
+		So(e.Expires, ShouldHappenWithin, time.Second, before.Add(30*time.Second))
+
+		_, err = c.Fetch("missing.test")
+		So(isDNSError(err).IsNotFound, ShouldBeTrue)
+	})
+}
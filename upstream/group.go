@@ -0,0 +1,338 @@
+package upstream
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+)
+
+var (
+	// ErrorNoUpstreams is returned by NewGroup if no Upstreams are configured.
+	ErrorNoUpstreams = errors.New("no upstreams are configured")
+	// ErrorStrategyUnsupported is returned by NewGroup if the Strategy is not one of those defined.
+	ErrorStrategyUnsupported = errors.New("strategy is not supported")
+
+	// DefaultFailureThreshold is the FailureThreshold used if none is configured.
+	DefaultFailureThreshold = 3
+	// DefaultCooldown is the Cooldown used if none is configured.
+	DefaultCooldown = 30 * time.Second
+)
+
+const (
+	// StrategyFailover is a Strategy that asks the upstreams one at a time, in order, until one answers.
+	StrategyFailover = Strategy("Failover")
+	// StrategyRace is a Strategy that asks every upstream at once. The first answer wins, and the
+	// other lookups are cancelled.
+	StrategyRace = Strategy("Race")
+	// StrategyRoundRobin is a Strategy that asks the upstreams in turn, spreading the lookups across them,
+	// failing over to the next if one does not answer.
+	StrategyRoundRobin = Strategy("RoundRobin")
+
+	scoreWeight   = 0.2 // of each outcome, in the Score
+	latencyWeight = 0.3 // of each latency, in the Latency
+)
+
+// Strategy is a string type for static consistency
+type Strategy string
+
+// Upstream is a resolver of a Group.
+type Upstream struct {
+	// Name identifies the upstream in its Health, and the log. Defaults to its index in the Upstreams.
+	Name string
+	// Resolver is the upstream, e.g. the Resolver of a Client.
+	Resolver cache.ResolverTTLFunc
+}
+
+// GroupConfig is the configuration of a Group.
+type GroupConfig struct {
+	// Upstreams are the resolvers of the Group, in order of preference.
+	Upstreams []Upstream
+	// Strategy is how the Upstreams are asked. Defaults to StrategyFailover.
+	Strategy Strategy
+	// FailureThreshold is how many consecutive failures open the circuit of an upstream. 0 means
+	// DefaultFailureThreshold, while a negative value disables the circuits, so no upstream is ever
+	// passed over. Not-found answers are not failures, here.
+	FailureThreshold int
+	// Cooldown is how long an open circuit passes over its upstream. After it, the upstream is asked again:
+	// a success closes the circuit, while a failure reopens it. Defaults to DefaultCooldown.
+	Cooldown time.Duration
+	// PreferFastest orders the Upstreams by their Latency, divided by their Score, rather than as configured,
+	// so StrategyFailover asks the fastest reliable one first. Upstreams yet to answer are asked first.
+	PreferFastest bool
+	// Logger is used to log the opening and closing of circuits. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+// Health is a snapshot of the health of an upstream of a Group.
+type Health struct {
+	// Name is the Name of the Upstream.
+	Name string
+	// Score is the moving average of the outcomes of its lookups, from 0 if they all failed, to 1 if none did.
+	Score float64
+	// Latency is the moving average of the time it took to answer, or 0 if it has not.
+	Latency time.Duration
+	// Successes is the number of lookups it answered, including not-found answers.
+	Successes uint64
+	// Failures is the number of lookups it failed.
+	Failures uint64
+	// ConsecutiveFailures is the number of lookups it failed since it last answered.
+	ConsecutiveFailures int
+	// Open is true if its circuit is open, passing over it until OpenUntil.
+	Open bool
+	// OpenUntil is when its circuit closes, or last closed.
+	OpenUntil time.Time
+	// LastError is the error of the last lookup it failed, if any.
+	LastError error
+}
+
+// Group is a resolver of several upstreams, asking them according to its Strategy, and passing over those
+// that keep failing. It is goro-safe.
+type Group struct {
+	members   []*member
+	strategy  Strategy
+	threshold int // < 0 disables the circuits
+	cooldown  time.Duration
+	fastest   bool
+	logger    *slog.Logger
+	next      atomic.Uint64 // the next turn, for StrategyRoundRobin
+}
+
+// member is an Upstream of a Group, with its health.
+type member struct {
+	Upstream
+
+	lock        sync.Mutex
+	score       float64
+	latency     time.Duration
+	successes   uint64
+	failures    uint64
+	consecutive int
+	openUntil   time.Time
+	lastErr     error
+}
+
+// NewGroup returns a Group with the GroupConfig, or an error if it is invalid.
+func NewGroup(config *GroupConfig) (*Group, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, ErrorNoUpstreams
+	}
+	switch config.Strategy {
+	case StrategyFailover, StrategyRace, StrategyRoundRobin, "":
+	default:
+		return nil, ErrorStrategyUnsupported
+	}
+	if config.Cooldown < 0 {
+		return nil, errors.New("Cooldown must be >= 0")
+	}
+
+	g := &Group{
+		strategy:  cmp.Or(config.Strategy, StrategyFailover),
+		threshold: cmp.Or(config.FailureThreshold, DefaultFailureThreshold),
+		cooldown:  cmp.Or(config.Cooldown, DefaultCooldown),
+		fastest:   config.PreferFastest,
+		logger:    config.Logger,
+	}
+	if g.logger == nil {
+		g.logger = slog.New(slog.DiscardHandler)
+	}
+	for i, u := range config.Upstreams {
+		if u.Resolver == nil {
+			return nil, fmt.Errorf("upstream %d has no Resolver", i)
+		}
+		u.Name = cmp.Or(u.Name, strconv.Itoa(i))
+		g.members = append(g.members, &member{Upstream: u, score: 1})
+	}
+	return g, nil
+}
+
+// Resolver returns LookupIPTTL as a cache.ResolverTTLFunc, for ConfigResolver.
+func (g *Group) Resolver() cache.ResolverTTLFunc {
+	return g.LookupIPTTL
+}
+
+// LookupIP returns the addresses of the host from the upstreams. It is a cache.ResolverContextFunc.
+func (g *Group) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := g.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL returns the addresses of the host, and their TTL, from the first upstream to answer,
+// according to the Strategy. A not-found answer is an answer. If every upstream fails, the last error
+// is returned. It is a cache.ResolverTTLFunc.
+//
+// Upstreams whose circuits are open are passed over, unless every one is.
+func (g *Group) LookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	members := g.candidates(time.Now())
+	if g.strategy == StrategyRace {
+		return g.race(ctx, host, members)
+	}
+
+	var err error
+	for _, m := range members {
+		if ctx.Err() != nil {
+			return nil, cache.TTLUnknown, ctx.Err()
+		}
+		var (
+			ips []net.IP
+			ttl time.Duration
+		)
+		if ips, ttl, err = g.lookup(ctx, m, host); answered(err) {
+			return ips, ttl, err
+		}
+	}
+	return nil, cache.TTLUnknown, err
+}
+
+// race asks the members at once, returning the first answer, or the last error if none answers.
+// The other lookups are cancelled, but not waited for.
+func (g *Group) race(ctx context.Context, host string, members []*member) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	results := make(chan result, len(members))
+	for _, m := range members {
+		go func() {
+			ips, ttl, err := g.lookup(ctx, m, host)
+			results <- result{ips, ttl, err}
+		}()
+	}
+
+	var err error
+	for range members {
+		r := <-results
+		if answered(r.err) {
+			return r.ips, r.ttl, r.err
+		}
+		err = r.err
+	}
+	return nil, cache.TTLUnknown, err
+}
+
+// lookup asks the member, and records the outcome, unless the Context was done first.
+func (g *Group) lookup(ctx context.Context, m *member, host string) ([]net.IP, time.Duration, error) {
+	start := time.Now()
+	ips, ttl, err := m.Resolver(ctx, host)
+	if ctx.Err() == nil {
+		g.record(m, time.Since(start), err)
+	}
+	return ips, ttl, err
+}
+
+// record updates the health of the member with the outcome of a lookup, opening or closing its circuit.
+func (g *Group) record(m *member, took time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if answered(err) {
+		if g.tripped(m) {
+			g.logger.Info("upstream circuit closed", "upstream", m.Name)
+			m.openUntil = now
+		}
+		m.successes++
+		m.consecutive = 0
+		m.score += scoreWeight * (1 - m.score)
+		if m.latency == 0 {
+			m.latency = took
+		} else {
+			m.latency += time.Duration(latencyWeight * float64(took-m.latency))
+		}
+		return
+	}
+
+	m.failures++
+	m.consecutive++
+	m.lastErr = err
+	m.score -= scoreWeight * m.score
+	if g.tripped(m) {
+		m.openUntil = now.Add(g.cooldown)
+		g.logger.Warn("upstream circuit opened", "upstream", m.Name, "failures", m.consecutive,
+			"cooldown", g.cooldown, "error", err)
+	}
+}
+
+// tripped returns true if the consecutive failures of the member open its circuit.
+// The lock of the member must be held.
+func (g *Group) tripped(m *member) bool {
+	return g.threshold > 0 && m.consecutive >= g.threshold
+}
+
+// candidates returns the members to ask, in the order to ask them: those whose circuits are closed,
+// or all of them if none are.
+func (g *Group) candidates(now time.Time) []*member {
+	members := slices.DeleteFunc(slices.Clone(g.members), func(m *member) bool { return m.open(now) })
+	if len(members) == 0 {
+		// better a failing upstream than none
+		members = slices.Clone(g.members)
+	}
+
+	if g.fastest {
+		costs := make(map[*member]float64, len(members))
+		for _, m := range members {
+			costs[m] = m.cost()
+		}
+		slices.SortStableFunc(members, func(a, b *member) int { return cmp.Compare(costs[a], costs[b]) })
+	}
+	if g.strategy == StrategyRoundRobin {
+		turn := int((g.next.Add(1) - 1) % uint64(len(members)))
+		members = append(members[turn:], members[:turn]...)
+	}
+	return members
+}
+
+// open returns true if the circuit of the member is open.
+func (m *member) open(now time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return now.Before(m.openUntil)
+}
+
+// cost returns the latency of the member, inflated by its unreliability, for PreferFastest.
+func (m *member) cost() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return float64(m.latency) / max(m.score, 0.01)
+}
+
+// Health returns a snapshot of the health of each upstream, in the order they were configured.
+func (g *Group) Health() []Health {
+	now := time.Now()
+	health := make([]Health, len(g.members))
+	for i, m := range g.members {
+		m.lock.Lock()
+		health[i] = Health{
+			Name:                m.Name,
+			Score:               m.score,
+			Latency:             m.latency,
+			Successes:           m.successes,
+			Failures:            m.failures,
+			ConsecutiveFailures: m.consecutive,
+			Open:                now.Before(m.openUntil),
+			OpenUntil:           m.openUntil,
+			LastError:           m.lastErr,
+		}
+		m.lock.Unlock()
+	}
+	return health
+}
+
+// answered returns true if the error is nil, or a not-found answer.
+func answered(err error) bool {
+	var dnsErr *net.DNSError
+	return err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
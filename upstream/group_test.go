@@ -0,0 +1,277 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeUpstream is an Upstream Resolver that counts its lookups, and answers its IP after its delay,
+// or fails while failing is true.
+type fakeUpstream struct {
+	ip      string
+	delay   time.Duration
+	failing atomic.Bool
+	calls   atomic.Int32
+}
+
+func (f *fakeUpstream) resolve(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	f.calls.Add(1)
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, cache.TTLUnknown, ctx.Err()
+	}
+	switch {
+	case f.failing.Load():
+		return nil, cache.TTLUnknown, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	case host == "missing.test":
+		return nil, cache.TTLUnknown, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IP{net.ParseIP(f.ip)}, time.Minute, nil
+}
+
+// newTestGroup returns a Group of the fakeUpstreams, named by their IPs, with the config.
+func newTestGroup(config GroupConfig, upstreams ...*fakeUpstream) *Group {
+	for _, f := range upstreams {
+		config.Upstreams = append(config.Upstreams, Upstream{Name: f.ip, Resolver: f.resolve})
+	}
+	g, err := NewGroup(&config)
+	So(err, ShouldBeNil)
+	return g
+}
+
+func TestGroupFailover(t *testing.T) {
+	Convey("When a Group fails over, the upstreams are asked in order until one answers", t, func() {
+		first, second := &fakeUpstream{ip: "192.0.2.1"}, &fakeUpstream{ip: "192.0.2.2"}
+		first.failing.Store(true)
+		g := newTestGroup(GroupConfig{}, first, second)
+
+		ips, ttl, err := g.LookupIPTTL(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.2")})
+		So(ttl, ShouldEqual, time.Minute)
+
+		health := g.Health()
+		So(health[0].Name, ShouldEqual, "192.0.2.1")
+		So(health[0].Failures, ShouldEqual, 1)
+		So(health[0].Score, ShouldBeLessThan, 1)
+		So(health[0].LastError, ShouldBeError)
+		So(health[1].Successes, ShouldEqual, 1)
+		So(health[1].Score, ShouldEqual, 1)
+	})
+
+	Convey("When an upstream answers not found, the answer is returned, and the next not asked", t, func() {
+		first, second := &fakeUpstream{ip: "192.0.2.1"}, &fakeUpstream{ip: "192.0.2.2"}
+		g := newTestGroup(GroupConfig{}, first, second)
+
+		_, err := g.LookupIP(context.Background(), "missing.test")
+		So(isDNSError(err).IsNotFound, ShouldBeTrue)
+		So(second.calls.Load(), ShouldBeZeroValue)
+		So(g.Health()[0].Successes, ShouldEqual, 1)
+	})
+
+	Convey("When every upstream fails, the last error is returned", t, func() {
+		first, second := &fakeUpstream{ip: "192.0.2.1"}, &fakeUpstream{ip: "192.0.2.2"}
+		first.failing.Store(true)
+		second.failing.Store(true)
+		g := newTestGroup(GroupConfig{}, first, second)
+
+		_, err := g.LookupIP(context.Background(), "a.test")
+		So(isDNSError(err), ShouldNotBeNil)
+		So(isDNSError(err).IsTemporary, ShouldBeTrue)
+	})
+}
+
+func TestGroupCircuit(t *testing.T) {
+	Convey("When an upstream keeps failing, its circuit opens, and closes once it answers after the cooldown", t, func() {
+		first, second := &fakeUpstream{ip: "192.0.2.1"}, &fakeUpstream{ip: "192.0.2.2"}
+		first.failing.Store(true)
+		g := newTestGroup(GroupConfig{FailureThreshold: 2, Cooldown: 50 * time.Millisecond}, first, second)
+
+		for range 4 {
+			_, err := g.LookupIP(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+		}
+		So(first.calls.Load(), ShouldEqual, 2)
+		So(g.Health()[0].Open, ShouldBeTrue)
+		So(g.Health()[0].ConsecutiveFailures, ShouldEqual, 2)
+
+		time.Sleep(60 * time.Millisecond)
+		_, err := g.LookupIP(context.Background(), "a.test") // fails again, reopening it
+		So(err, ShouldBeNil)
+		So(first.calls.Load(), ShouldEqual, 3)
+		So(g.Health()[0].Open, ShouldBeTrue)
+
+		time.Sleep(60 * time.Millisecond)
+		first.failing.Store(false)
+		ips, err := g.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.1")})
+		So(g.Health()[0].Open, ShouldBeFalse)
+		So(g.Health()[0].ConsecutiveFailures, ShouldBeZeroValue)
+	})
+
+	Convey("When every circuit is open, the upstreams are asked anyway", t, func() {
+		only := &fakeUpstream{ip: "192.0.2.1"}
+		only.failing.Store(true)
+		g := newTestGroup(GroupConfig{FailureThreshold: 1}, only)
+
+		_, err := g.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeError)
+		So(g.Health()[0].Open, ShouldBeTrue)
+
+		only.failing.Store(false)
+		_, err = g.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(only.calls.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When the FailureThreshold is negative, circuits never open", t, func() {
+		first, second := &fakeUpstream{ip: "192.0.2.1"}, &fakeUpstream{ip: "192.0.2.2"}
+		first.failing.Store(true)
+		g := newTestGroup(GroupConfig{FailureThreshold: -1}, first, second)
+
+		for range 5 {
+			_, err := g.LookupIP(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+		}
+		So(first.calls.Load(), ShouldEqual, 5)
+		So(g.Health()[0].Open, ShouldBeFalse)
+		So(g.Health()[0].ConsecutiveFailures, ShouldEqual, 5)
+	})
+
+	Convey("When the caller's Context is done, the outcome is not held against the upstream", t, func() {
+		slow := &fakeUpstream{ip: "192.0.2.1", delay: time.Second}
+		g := newTestGroup(GroupConfig{FailureThreshold: 1}, slow)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := g.LookupIP(ctx, "a.test")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(g.Health()[0].Failures, ShouldBeZeroValue)
+		So(g.Health()[0].Open, ShouldBeFalse)
+	})
+}
+
+func TestGroupRace(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Group races, the first answer wins, and the losers are cancelled, not failed", t, func() {
+		slow, fast := &fakeUpstream{ip: "192.0.2.1", delay: time.Second}, &fakeUpstream{ip: "192.0.2.2"}
+		g := newTestGroup(GroupConfig{Strategy: StrategyRace}, slow, fast)
+
+		start := time.Now()
+		ips, err := g.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.2")})
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+
+		time.Sleep(10 * time.Millisecond) // the loser notices
+		So(slow.calls.Load(), ShouldEqual, 1)
+		So(g.Health()[0].Failures, ShouldBeZeroValue)
+	})
+
+	Convey("When a racer fails, the others are still waited for", t, func() {
+		failing, slow := &fakeUpstream{ip: "192.0.2.1"}, &fakeUpstream{ip: "192.0.2.2", delay: 20 * time.Millisecond}
+		failing.failing.Store(true)
+		g := newTestGroup(GroupConfig{Strategy: StrategyRace}, failing, slow)
+
+		ips, err := g.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.2")})
+		So(g.Health()[0].Failures, ShouldEqual, 1)
+	})
+}
+
+func TestGroupRoundRobin(t *testing.T) {
+	Convey("When a Group round-robins, the lookups are spread across the upstreams", t, func() {
+		upstreams := []*fakeUpstream{{ip: "192.0.2.1"}, {ip: "192.0.2.2"}, {ip: "192.0.2.3"}}
+		g := newTestGroup(GroupConfig{Strategy: StrategyRoundRobin}, upstreams...)
+
+		var answers []string
+		for range 6 {
+			ips, err := g.LookupIP(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+			answers = append(answers, ips[0].String())
+		}
+		So(answers, ShouldResemble, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.1", "192.0.2.2", "192.0.2.3"})
+
+		upstreams[1].failing.Store(true)
+		_, err := g.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		ips, err := g.LookupIP(context.Background(), "a.test") // its turn, but it fails over
+		So(err, ShouldBeNil)
+		So(ips[0].String(), ShouldEqual, "192.0.2.3")
+	})
+}
+
+func TestGroupPreferFastest(t *testing.T) {
+	Convey("When a Group prefers the fastest, the upstreams are asked by latency, once it is known", t, func() {
+		slow, fast := &fakeUpstream{ip: "192.0.2.1", delay: 20 * time.Millisecond}, &fakeUpstream{ip: "192.0.2.2"}
+		g := newTestGroup(GroupConfig{PreferFastest: true}, slow, fast)
+
+		for range 4 {
+			_, err := g.LookupIP(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+		}
+		So(slow.calls.Load(), ShouldEqual, 1) // until it was measured
+		So(fast.calls.Load(), ShouldEqual, 3)
+		So(g.Health()[0].Latency, ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+	})
+}
+
+func TestNewGroup(t *testing.T) {
+	Convey("When a Group is configured, the defaults are applied, and invalid values refused", t, func() {
+		g := newTestGroup(GroupConfig{}, &fakeUpstream{ip: "192.0.2.1"})
+		So(g.strategy, ShouldEqual, StrategyFailover)
+		So(g.threshold, ShouldEqual, DefaultFailureThreshold)
+		So(g.cooldown, ShouldEqual, DefaultCooldown)
+
+		g, err := NewGroup(&GroupConfig{Upstreams: []Upstream{{Resolver: (&fakeUpstream{}).resolve}}})
+		So(err, ShouldBeNil)
+		So(g.Health()[0].Name, ShouldEqual, "0")
+
+		_, err = NewGroup(&GroupConfig{})
+		So(err, ShouldEqual, ErrorNoUpstreams)
+		_, err = NewGroup(&GroupConfig{Upstreams: []Upstream{{Name: "none"}}})
+		So(err, ShouldBeError)
+		_, err = NewGroup(&GroupConfig{Upstreams: []Upstream{{Resolver: (&fakeUpstream{}).resolve}}, Strategy: "Random"})
+		So(err, ShouldEqual, ErrorStrategyUnsupported)
+		_, err = NewGroup(&GroupConfig{Upstreams: []Upstream{{Resolver: (&fakeUpstream{}).resolve}}, Cooldown: -1})
+		So(err, ShouldBeError)
+	})
+}
+
+func TestGroupOfClients(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When a Group of Clients is the resolver of a cache, a silent server is failed over", t, func() {
+		silent := newFakeServer(func(*message, bool) *message { return nil })
+		defer silent.close()
+		s := newZoneServer(testZone)
+		defer s.close()
+
+		g, err := NewGroup(&GroupConfig{Upstreams: []Upstream{
+			{Name: "silent", Resolver: newTestClient(Config{}, silent).Resolver()},
+			{Name: "zone", Resolver: newTestClient(Config{}, s).Resolver()},
+		}})
+		So(err, ShouldBeNil)
+
+		c, err := cache.NewSimple(cache.NewConfigOption(cache.ConfigResolver, g.Resolver()))
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		ips, err := c.Fetch("a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 2)
+		So(g.Health()[0].Failures, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// dnsMessageType is the media type of DNS-over-HTTPS requests and answers.
+const dnsMessageType = "application/dns-message"
+
+// httpsTransport sends queries via DNS-over-HTTPS, per RFC 8484.
+type httpsTransport struct {
+	client *http.Client
+	method string
+	owned  bool // the client is ours, so its idle connections are closed by close
+}
+
+// newHTTPSTransport returns an httpsTransport using the http.Client, or one of its own with the
+// tls.Config, if it is nil.
+func newHTTPSTransport(client *http.Client, config *tls.Config, method string) *httpsTransport {
+	t := &httpsTransport{client: client, method: method}
+	if client == nil {
+		t.owned = true
+		t.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:             http.ProxyFromEnvironment,
+				TLSClientConfig:   config,
+				ForceAttemptHTTP2: true,
+			},
+		}
+	}
+	return t
+}
+
+// exchange sends the query to the server, a URL, and returns the answer.
+// The id of the query is 0, for the benefit of HTTP caches, per RFC 8484 4.1.
+func (t *httpsTransport) exchange(ctx context.Context, server string, query *message) (*message, error) {
+	query.id = 0
+	b, err := query.pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if t.method == http.MethodGet {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		values := u.Query()
+		values.Set("dns", base64.RawURLEncoding.EncodeToString(b))
+		u.RawQuery = values.Encode()
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", dnsMessageType)
+	}
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server answered HTTP %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != dnsMessageType {
+		return nil, fmt.Errorf("server answered with %q, not %s", mediaType, dnsMessageType)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+	answer, err := parseMessage(body)
+	if err != nil {
+		return nil, err
+	}
+	if !answers(query, answer) {
+		return nil, errMismatch
+	}
+	return answer, nil
+}
+
+// close closes the idle connections of the http.Client, if it is our own.
+func (t *httpsTransport) close() {
+	if t.owned {
+		t.client.CloseIdleConnections()
+	}
+}
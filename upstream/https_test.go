@@ -0,0 +1,92 @@
+package upstream
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTTPSTransport(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When lookups are made over HTTPS, either method may be used", t, func() {
+		s := &fakeServer{handler: testZone.answer}
+		srv := httptest.NewTLSServer(s)
+		defer srv.Close()
+
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			c, err := New(&Config{
+				Protocol:   ProtocolHTTPS,
+				Servers:    []string{srv.URL + "/dns-query"},
+				HTTPClient: srv.Client(),
+				HTTPMethod: method,
+			})
+			So(err, ShouldBeNil)
+
+			ips, err := c.LookupIP(context.Background(), "cname.test")
+			So(err, ShouldBeNil)
+			So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")})
+			So(s.lastQuery().id, ShouldBeZeroValue)
+			s.lock.Lock()
+			So(s.lastMethod, ShouldEqual, method)
+			s.lock.Unlock()
+
+			_, err = c.LookupIP(context.Background(), "missing.test")
+			So(isDNSError(err).IsNotFound, ShouldBeTrue)
+		}
+	})
+
+	Convey("When the HTTPClient is not set, one using the TLSConfig is made, and closed by Close", t, func() {
+		s := &fakeServer{handler: testZone.answer}
+		srv := httptest.NewTLSServer(s)
+		defer srv.Close()
+
+		c, err := New(&Config{
+			Protocol:  ProtocolHTTPS,
+			Servers:   []string{srv.URL + "/dns-query?other=yes"},
+			TLSConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig,
+		})
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		ips, err := c.LookupIP(context.Background(), "both.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 2)
+	})
+
+	Convey("When an HTTPS server fails, the next is asked", t, func() {
+		failing := httptest.NewTLSServer(http.NotFoundHandler())
+		defer failing.Close()
+		s := &fakeServer{handler: testZone.answer}
+		srv := httptest.NewTLSServer(s)
+		defer srv.Close()
+
+		c, err := New(&Config{
+			Protocol:   ProtocolHTTPS,
+			Servers:    []string{failing.URL, srv.URL},
+			HTTPClient: srv.Client(), // trusts both, as they share a certificate
+		})
+		So(err, ShouldBeNil)
+
+		ips, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 2)
+		So(s.httpQueries.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When the HTTPS options are invalid, an error is returned", t, func() {
+		_, err := New(&Config{Protocol: ProtocolHTTPS, Servers: []string{"http://dns.example/dns-query"}})
+		So(err, ShouldBeError)
+		_, err = New(&Config{Protocol: ProtocolHTTPS, Servers: []string{"dns.example"}})
+		So(err, ShouldBeError)
+		_, err = New(&Config{Protocol: ProtocolHTTPS, Servers: []string{"https://dns.example/dns-query"}, HTTPMethod: http.MethodPut})
+		So(err, ShouldBeError)
+		_, err = New(&Config{Protocol: "QUIC", Servers: []string{"dns.example"}})
+		So(err, ShouldEqual, ErrorProtocolUnsupported)
+	})
+}
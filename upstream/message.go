@@ -0,0 +1,333 @@
+package upstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS record types, classes, and response codes used by the Client.
+const (
+	typeA     uint16 = 1
+	typeCNAME uint16 = 5
+	typeAAAA  uint16 = 28
+	typeOPT   uint16 = 41
+
+	classINET uint16 = 1
+
+	rcodeSuccess   = 0
+	rcodeNameError = 3
+
+	headerLen  = 12
+	maxPointer = 16 // compression pointers followed per name, before giving up
+)
+
+var (
+	errMessageShort = errors.New("dns message is truncated")
+	errMessageName  = errors.New("dns message has an invalid name")
+)
+
+// header is the fixed header of a DNS message.
+type header struct {
+	id                 uint16
+	response           bool
+	opcode             int
+	authoritative      bool
+	truncated          bool
+	recursionDesired   bool
+	recursionAvailable bool
+	rcode              int
+}
+
+// flags returns the second word of the header.
+func (h header) flags() uint16 {
+	f := uint16(h.opcode&0xf)<<11 | uint16(h.rcode&0xf)
+	if h.response {
+		f |= 1 << 15
+	}
+	if h.authoritative {
+		f |= 1 << 10
+	}
+	if h.truncated {
+		f |= 1 << 9
+	}
+	if h.recursionDesired {
+		f |= 1 << 8
+	}
+	if h.recursionAvailable {
+		f |= 1 << 7
+	}
+	return f
+}
+
+// setFlags sets the header from the second word of a header.
+func (h *header) setFlags(f uint16) {
+	h.response = f&(1<<15) != 0
+	h.opcode = int(f>>11) & 0xf
+	h.authoritative = f&(1<<10) != 0
+	h.truncated = f&(1<<9) != 0
+	h.recursionDesired = f&(1<<8) != 0
+	h.recursionAvailable = f&(1<<7) != 0
+	h.rcode = int(f & 0xf)
+}
+
+// question is an entry of the question section of a DNS message.
+type question struct {
+	name  string // fully-qualified
+	qtype uint16
+	class uint16
+}
+
+// record is a resource record. The data of the types the Client uses are decoded,
+// the rest are kept raw.
+type record struct {
+	name  string // fully-qualified
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+
+	ip     net.IP // A and AAAA
+	target string // CNAME
+}
+
+// message is a DNS message. Only what the Client needs is supported: name compression is
+// understood, but never used when packing.
+type message struct {
+	header
+	questions   []question
+	answers     []record
+	authorities []record
+	additionals []record
+}
+
+// newQuery returns a recursive query for the name and type, with an EDNS0 OPT record advertising
+// the UDP payload size, unless it is 0.
+func newQuery(id uint16, name string, qtype uint16, udpSize int) *message {
+	m := &message{
+		header:    header{id: id, recursionDesired: true},
+		questions: []question{{name: fqdn(name), qtype: qtype, class: classINET}},
+	}
+	if udpSize > 0 {
+		m.additionals = []record{{name: ".", rtype: typeOPT, class: uint16(udpSize)}}
+	}
+	return m
+}
+
+// pack returns the message in wire format.
+func (m *message) pack() ([]byte, error) {
+	b := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	binary.BigEndian.PutUint16(b[2:], m.flags())
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.authorities)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.additionals)))
+
+	var err error
+	for _, q := range m.questions {
+		if b, err = appendName(b, q.name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.qtype)
+		b = binary.BigEndian.AppendUint16(b, q.class)
+	}
+	for _, section := range [][]record{m.answers, m.authorities, m.additionals} {
+		for _, r := range section {
+			if b, err = appendRecord(b, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendRecord appends the record in wire format. The decoded fields take precedence over data.
+func appendRecord(b []byte, r record) ([]byte, error) {
+	var err error
+	if b, err = appendName(b, r.name); err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, r.rtype)
+	b = binary.BigEndian.AppendUint16(b, r.class)
+	b = binary.BigEndian.AppendUint32(b, r.ttl)
+
+	data := r.data
+	switch {
+	case r.rtype == typeA && r.ip != nil:
+		data = r.ip.To4()
+	case r.rtype == typeAAAA && r.ip != nil:
+		data = r.ip.To16()
+	case r.rtype == typeCNAME && r.target != "":
+		if data, err = appendName(nil, r.target); err != nil {
+			return nil, err
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...), nil
+}
+
+// appendName appends the name in wire format, uncompressed.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = fqdn(name)
+	if len(name) > 254 {
+		return nil, errMessageName
+	}
+	if name == "." {
+		return append(b, 0), nil
+	}
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errMessageName
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// parseMessage returns the message in b, which is in wire format.
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < headerLen {
+		return nil, errMessageShort
+	}
+	m := &message{}
+	m.id = binary.BigEndian.Uint16(b[0:])
+	m.setFlags(binary.BigEndian.Uint16(b[2:]))
+	var (
+		qdcount = int(binary.BigEndian.Uint16(b[4:]))
+		counts  = []int{
+			int(binary.BigEndian.Uint16(b[6:])),
+			int(binary.BigEndian.Uint16(b[8:])),
+			int(binary.BigEndian.Uint16(b[10:])),
+		}
+		off = headerLen
+		err error
+	)
+
+	for range qdcount {
+		var q question
+		if q.name, off, err = parseName(b, off); err != nil {
+			return nil, err
+		}
+		if off+4 > len(b) {
+			return nil, errMessageShort
+		}
+		q.qtype = binary.BigEndian.Uint16(b[off:])
+		q.class = binary.BigEndian.Uint16(b[off+2:])
+		off += 4
+		m.questions = append(m.questions, q)
+	}
+
+	sections := []*[]record{&m.answers, &m.authorities, &m.additionals}
+	for i, count := range counts {
+		for range count {
+			var r record
+			if r, off, err = parseRecord(b, off); err != nil {
+				return nil, err
+			}
+			*sections[i] = append(*sections[i], r)
+		}
+	}
+	return m, nil
+}
+
+// parseRecord returns the record at off in b, and the offset following it.
+func parseRecord(b []byte, off int) (record, int, error) {
+	var (
+		r   record
+		err error
+	)
+	if r.name, off, err = parseName(b, off); err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(b) {
+		return r, 0, errMessageShort
+	}
+	r.rtype = binary.BigEndian.Uint16(b[off:])
+	r.class = binary.BigEndian.Uint16(b[off+2:])
+	r.ttl = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+length > len(b) {
+		return r, 0, errMessageShort
+	}
+	r.data = b[off : off+length]
+
+	switch r.rtype {
+	case typeA:
+		if length != net.IPv4len {
+			return r, 0, errors.New("dns message has an invalid A record")
+		}
+		r.ip = net.IP(r.data).To16()
+	case typeAAAA:
+		if length != net.IPv6len {
+			return r, 0, errors.New("dns message has an invalid AAAA record")
+		}
+		r.ip = net.IP(r.data)
+	case typeCNAME:
+		// the target may be compressed, pointing anywhere in the message
+		if r.target, _, err = parseName(b, off); err != nil {
+			return r, 0, err
+		}
+	}
+	return r, off + length, nil
+}
+
+// parseName returns the name at off in b, following compression pointers, and the offset following it.
+func parseName(b []byte, off int) (string, int, error) {
+	var (
+		name     []byte
+		next     = -1 // the offset following the name, once a pointer has been followed
+		pointers int
+	)
+	for {
+		if off >= len(b) {
+			return "", 0, errMessageShort
+		}
+		c := int(b[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				if next < 0 {
+					next = off + 1
+				}
+				if len(name) == 0 {
+					return ".", next, nil
+				}
+				if len(name) > 254 {
+					return "", 0, errMessageName
+				}
+				return string(name), next, nil
+			}
+			if off+1+c > len(b) {
+				return "", 0, errMessageShort
+			}
+			name = append(name, b[off+1:off+1+c]...)
+			name = append(name, '.')
+			off += 1 + c
+		case 0xc0:
+			if off+2 > len(b) {
+				return "", 0, errMessageShort
+			}
+			if pointers++; pointers > maxPointer {
+				return "", 0, errMessageName
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		default:
+			return "", 0, errMessageName
+		}
+	}
+}
+
+// fqdn returns the name, fully-qualified.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,66 @@
+package upstream
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMessagePackParse(t *testing.T) {
+	Convey("When a message is packed and parsed, it is the same message", t, func() {
+		m := newQuery(1234, "www.example.com", typeA, 1232)
+		m.response = true
+		m.recursionAvailable = true
+		m.answers = []record{
+			{name: "www.example.com.", rtype: typeCNAME, class: classINET, ttl: 60, target: "example.com."},
+			{name: "example.com.", rtype: typeA, class: classINET, ttl: 30, ip: net.ParseIP("192.0.2.1")},
+			{name: "example.com.", rtype: typeAAAA, class: classINET, ttl: 30, ip: net.ParseIP("2001:db8::1")},
+		}
+
+		b, err := m.pack()
+		So(err, ShouldBeNil)
+		p, err := parseMessage(b)
+		So(err, ShouldBeNil)
+
+		So(p.header, ShouldResemble, m.header)
+		So(p.questions, ShouldResemble, []question{{name: "www.example.com.", qtype: typeA, class: classINET}})
+		So(p.answers, ShouldHaveLength, 3)
+		So(p.answers[0].target, ShouldEqual, "example.com.")
+		So(p.answers[1].ip.Equal(net.ParseIP("192.0.2.1")), ShouldBeTrue)
+		So(p.answers[2].ip.Equal(net.ParseIP("2001:db8::1")), ShouldBeTrue)
+		So(p.answers[2].ttl, ShouldEqual, 30)
+		So(p.additionals, ShouldHaveLength, 1)
+		So(p.additionals[0].rtype, ShouldEqual, typeOPT)
+		So(p.additionals[0].class, ShouldEqual, 1232)
+	})
+
+	Convey("When a message uses name compression, the names are expanded", t, func() {
+		b := []byte{
+			0, 1, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0, // header
+			3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 5, 0, 1, // question
+			0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 16, // CNAME to the example.com in the question
+		}
+		m, err := parseMessage(b)
+		So(err, ShouldBeNil)
+		So(m.answers, ShouldHaveLength, 1)
+		So(m.answers[0].name, ShouldEqual, "www.example.com.")
+		So(m.answers[0].target, ShouldEqual, "example.com.")
+	})
+
+	Convey("When a message is malformed, an error is returned, not a panic", t, func() {
+		_, err := parseMessage([]byte{0, 1, 0x81})
+		So(err, ShouldEqual, errMessageShort)
+
+		loop := []byte{0, 1, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12, 0, 1, 0, 1} // a pointer to itself
+		_, err = parseMessage(loop)
+		So(err, ShouldEqual, errMessageName)
+
+		long := []byte{0, 1, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0, 10, 'a'}
+		_, err = parseMessage(long)
+		So(err, ShouldEqual, errMessageShort)
+
+		_, err = appendName(nil, "a..b")
+		So(err, ShouldEqual, errMessageName)
+	})
+}
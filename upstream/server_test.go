@@ -0,0 +1,303 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// zone is the records a fakeServer answers with, by lowercase fully-qualified name.
+// Names absent from it are answered with a name error.
+type zone map[string][]record
+
+// handler answers the query, or returns nil to not answer it.
+type handler func(query *message, tcp bool) *message
+
+// fakeServer is an in-process DNS server, listening on UDP and TCP on the same loopback port,
+// or on TLS alone. UDP answers larger than the query allows are truncated, as a real server's would be.
+// Stream connections are kept open, and the queries on them answered concurrently.
+// It is also an http.Handler, answering DNS-over-HTTPS queries.
+type fakeServer struct {
+	udp     net.PacketConn
+	tcp     net.Listener
+	handler handler
+
+	udpQueries  atomic.Int32
+	tcpQueries  atomic.Int32
+	httpQueries atomic.Int32
+	accepted    atomic.Int32 // stream connections
+	lock        sync.Mutex
+	last        *message // the last query received
+	lastMethod  string   // of the last HTTP query
+	conns       map[net.Conn]bool
+	wg          sync.WaitGroup
+}
+
+// newFakeServer returns a running fakeServer with the handler.
+func newFakeServer(h handler) *fakeServer {
+	s := &fakeServer{handler: h}
+	for {
+		udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+		if err != nil {
+			// the port is taken for TCP, try another
+			udp.Close()
+			continue
+		}
+		s.udp, s.tcp = udp, tcp
+		break
+	}
+
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+// newFakeTLSServer returns a running fakeServer with the handler, listening on TLS with the tls.Config.
+func newFakeTLSServer(h handler, config *tls.Config) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	s := &fakeServer{handler: h, tcp: tls.NewListener(l, config)}
+
+	s.wg.Add(1)
+	go s.serveTCP()
+	return s
+}
+
+// newZoneServer returns a running fakeServer answering from the zone.
+func newZoneServer(z zone) *fakeServer {
+	return newFakeServer(z.answer)
+}
+
+// answer is a handler answering from the zone.
+func (z zone) answer(query *message, _ bool) *message {
+	resp := &message{header: header{response: true, recursionAvailable: true}}
+	q := query.questions[0]
+	if _, ok := z[strings.ToLower(q.name)]; !ok {
+		resp.rcode = rcodeNameError
+		return resp
+	}
+
+	// include the CNAME chain, and the records of the type at its end
+	name := q.name
+	for range maxCNAMEs {
+		next := ""
+		for _, r := range z[strings.ToLower(name)] {
+			switch {
+			case r.rtype == typeCNAME:
+				resp.answers = append(resp.answers, r)
+				next = r.target
+			case r.rtype == q.qtype:
+				resp.answers = append(resp.answers, r)
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	return resp
+}
+
+// addr returns the address the server listens on.
+func (s *fakeServer) addr() string {
+	return s.tcp.Addr().String()
+}
+
+// lastQuery returns the last query received.
+func (s *fakeServer) lastQuery() *message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.last
+}
+
+// close stops the server, closing its connections, and waits for it.
+func (s *fakeServer) close() {
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	s.tcp.Close()
+	s.lock.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+// reply returns the packed answer to the packed query, or nil if there is none.
+// UDP answers are truncated to the size the query allows.
+func (s *fakeServer) reply(b []byte, tcp bool) []byte {
+	query, err := parseMessage(b)
+	if err != nil || len(query.questions) != 1 {
+		return nil
+	}
+	s.lock.Lock()
+	s.last = query
+	s.lock.Unlock()
+
+	resp := s.handler(query, tcp)
+	if resp == nil {
+		return nil
+	}
+	resp.id = query.id
+	resp.response = true
+	resp.questions = query.questions
+	out, err := resp.pack()
+	if err != nil {
+		return nil
+	}
+
+	limit := 512
+	for _, r := range query.additionals {
+		if r.rtype == typeOPT {
+			limit = max(limit, int(r.class))
+		}
+	}
+	if !tcp && len(out) > limit {
+		resp.truncated = true
+		resp.answers = nil
+		out, _ = resp.pack()
+	}
+	return out
+}
+
+func (s *fakeServer) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.udpQueries.Add(1)
+		if out := s.reply(buf[:n], false); out != nil {
+			s.udp.WriteTo(out, from)
+		}
+	}
+}
+
+func (s *fakeServer) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+		s.lock.Lock()
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]bool)
+		}
+		s.conns[conn] = true
+		s.lock.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+			s.lock.Lock()
+			delete(s.conns, conn)
+			s.lock.Unlock()
+		}()
+	}
+}
+
+// serveConn answers the queries on the stream connection, concurrently, until it is closed.
+func (s *fakeServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	var (
+		write sync.Mutex
+		wg    sync.WaitGroup
+	)
+	defer wg.Wait()
+
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		s.tcpQueries.Add(1)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out := s.reply(b, true); out != nil {
+				write.Lock()
+				defer write.Unlock()
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(out))), out...))
+			}
+		}()
+	}
+}
+
+// ServeHTTP answers DNS-over-HTTPS queries, of either method.
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.httpQueries.Add(1)
+	s.lock.Lock()
+	s.lastMethod = req.Method
+	s.lock.Unlock()
+
+	var (
+		b   []byte
+		err error
+	)
+	if req.Method == http.MethodGet {
+		b, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+	} else if req.Header.Get("Content-Type") == dnsMessageType {
+		b, err = io.ReadAll(req.Body)
+	} else {
+		err = errors.New("wrong content type")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := s.reply(b, true)
+	if out == nil {
+		http.Error(w, "no answer", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", dnsMessageType)
+	w.Write(out)
+}
+
+// isDNSError returns the error as a *net.DNSError, or nil if it is not one.
+func isDNSError(err error) *net.DNSError {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr
+	}
+	return nil
+}
+
+// a returns an A record for the name.
+func a(name, ip string, ttl uint32) record {
+	return record{name: name, rtype: typeA, class: classINET, ttl: ttl, ip: net.ParseIP(ip)}
+}
+
+// aaaa returns an AAAA record for the name.
+func aaaa(name, ip string, ttl uint32) record {
+	return record{name: name, rtype: typeAAAA, class: classINET, ttl: ttl, ip: net.ParseIP(ip)}
+}
+
+// cname returns a CNAME record for the name.
+func cname(name, target string, ttl uint32) record {
+	return record{name: name, rtype: typeCNAME, class: classINET, ttl: ttl, target: target}
+}
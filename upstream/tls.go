@@ -0,0 +1,278 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// errConnClosed is returned when a query could not be sent, as its connection had closed.
+	errConnClosed = errors.New("connection is closed")
+	// errConnIdle closes connections left idle.
+	errConnIdle = errors.New("connection is idle")
+)
+
+// tlsTransport sends queries via DNS-over-TLS, per RFC 7858. A connection to each server is kept
+// open until it is idle for a while, and queries are pipelined over it, their answers being matched
+// by id in whatever order they arrive.
+type tlsTransport struct {
+	config *tls.Config
+	idle   time.Duration
+
+	lock  sync.Mutex
+	conns map[string]*tlsConn
+	dials map[string]*tlsDial // in progress
+	gen   uint64              // incremented by close, so that the conns of dials begun before are not kept
+	wg    sync.WaitGroup      // the readers of the conns
+}
+
+// tlsDial is a dial in progress, whose outcome is shared by the callers waiting for it.
+type tlsDial struct {
+	done chan struct{} // closed once conn or err is set
+	conn *tlsConn
+	err  error
+}
+
+// newTLSTransport returns a tlsTransport with the tls.Config, which may be nil, and idle timeout.
+func newTLSTransport(config *tls.Config, idle time.Duration) *tlsTransport {
+	return &tlsTransport{
+		config: config,
+		idle:   idle,
+		conns:  make(map[string]*tlsConn),
+		dials:  make(map[string]*tlsDial),
+	}
+}
+
+// exchange sends the query to the server over its connection, and returns the answer.
+// If the connection closes before the query is sent, it is sent over a new one.
+func (t *tlsTransport) exchange(ctx context.Context, server string, query *message) (*message, error) {
+	var err error
+	for range 2 {
+		var c *tlsConn
+		if c, err = t.conn(ctx, server); err != nil {
+			return nil, err
+		}
+
+		var resp *message
+		if resp, err = c.exchange(ctx, query); !errors.Is(err, errConnClosed) {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+// conn returns the open connection to the server, dialing it if there is none.
+// Dials are made unlocked, so a slow server doesn't hold up the others, and callers wanting a
+// connection to a server being dialed wait for, and share the outcome of, that dial.
+func (t *tlsTransport) conn(ctx context.Context, server string) (*tlsConn, error) {
+	t.lock.Lock()
+	if c, ok := t.conns[server]; ok && c.open() {
+		t.lock.Unlock()
+		return c, nil
+	}
+	if d, ok := t.dials[server]; ok {
+		t.lock.Unlock()
+		select {
+		case <-d.done:
+			return d.conn, d.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	d := &tlsDial{done: make(chan struct{})}
+	t.dials[server] = d
+	gen := t.gen
+	t.lock.Unlock()
+
+	d.conn, d.err = t.dial(ctx, server)
+
+	t.lock.Lock()
+	if t.dials[server] == d {
+		delete(t.dials, server)
+	}
+	switch {
+	case d.err != nil:
+	case t.gen != gen:
+		// closed while dialing, so nobody would close it, or wait for its reader
+		d.conn.fail(net.ErrClosed)
+		d.conn, d.err = nil, net.ErrClosed
+	default:
+		t.conns[server] = d.conn
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			d.conn.read()
+		}()
+	}
+	t.lock.Unlock()
+	close(d.done)
+	return d.conn, d.err
+}
+
+// dial returns a new connection to the server.
+func (t *tlsTransport) dial(ctx context.Context, server string) (*tlsConn, error) {
+	config := &tls.Config{}
+	if t.config != nil {
+		config = t.config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(server)
+	}
+	d := tls.Dialer{Config: config}
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	return newTLSConn(conn, t.idle), nil
+}
+
+// close closes the connections, and waits for their readers to finish. Dials in progress fail with
+// net.ErrClosed, closing their connections, and later callers dial anew.
+func (t *tlsTransport) close() {
+	t.lock.Lock()
+	t.gen++
+	for server, c := range t.conns {
+		c.fail(net.ErrClosed)
+		delete(t.conns, server)
+	}
+	clear(t.dials)
+	t.lock.Unlock()
+	t.wg.Wait()
+}
+
+// tlsConn is a DNS-over-TLS connection, with the queries in flight over it.
+type tlsConn struct {
+	conn        net.Conn
+	write       sync.Mutex // held while writing a query
+	idleTimeout time.Duration
+
+	lock    sync.Mutex
+	pending map[uint16]chan *message // by query id
+	err     error                    // why the connection closed, if it has
+	idle    *time.Timer              // closes the connection, once idle
+}
+
+// newTLSConn returns a tlsConn of the connection, which closes once idle for the timeout.
+func newTLSConn(conn net.Conn, idleTimeout time.Duration) *tlsConn {
+	c := &tlsConn{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		pending:     make(map[uint16]chan *message),
+	}
+	c.idle = time.AfterFunc(idleTimeout, func() {
+		c.lock.Lock()
+		idle := len(c.pending) == 0
+		c.lock.Unlock()
+		if idle {
+			c.fail(errConnIdle)
+		}
+	})
+	return c
+}
+
+// open returns true if the connection has not closed.
+func (c *tlsConn) open() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err == nil
+}
+
+// exchange sends the query, with an id unique among those in flight, and waits for the answer.
+// errConnClosed is returned if the connection had closed before the query could be sent.
+func (c *tlsConn) exchange(ctx context.Context, query *message) (*message, error) {
+	answer := make(chan *message, 1)
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, errConnClosed
+	}
+	for {
+		query.id = uint16(rand.Uint32())
+		if _, ok := c.pending[query.id]; !ok {
+			break
+		}
+	}
+	c.pending[query.id] = answer
+	c.idle.Stop()
+	c.lock.Unlock()
+	defer c.done(query.id)
+
+	b, err := query.pack()
+	if err != nil {
+		return nil, err
+	}
+	c.write.Lock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	err = writeFramed(c.conn, b)
+	c.write.Unlock()
+	if err != nil {
+		// a partial write leaves the stream unusable
+		c.fail(err)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-answer:
+		if !ok {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			return nil, c.err
+		}
+		if !answers(query, resp) {
+			return nil, errMismatch
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// done forgets the query, starting the idle timer if no others are in flight.
+func (c *tlsConn) done(id uint16) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pending, id)
+	if len(c.pending) == 0 && c.err == nil {
+		c.idle.Reset(c.idleTimeout)
+	}
+}
+
+// read delivers answers to the queries in flight, until the connection fails.
+func (c *tlsConn) read() {
+	for {
+		resp, err := readFramed(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.lock.Lock()
+		if answer, ok := c.pending[resp.id]; ok {
+			delete(c.pending, resp.id)
+			answer <- resp
+		}
+		c.lock.Unlock()
+	}
+}
+
+// fail closes the connection for the reason, failing the queries in flight, unless it already has.
+func (c *tlsConn) fail(reason error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = reason
+	c.idle.Stop()
+	c.conn.Close()
+	for id, answer := range c.pending {
+		close(answer)
+		delete(c.pending, id)
+	}
+}
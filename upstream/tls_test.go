@@ -0,0 +1,205 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTLSConfigs returns the tls.Config of a server for 127.0.0.1, and that of a client trusting it.
+func newTLSConfigs() (*tls.Config, *tls.Config) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	return &tls.Config{Certificates: srv.TLS.Certificates}, srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+}
+
+// slowly is a handler answering from the zone, but only after the delay for slow.test.
+func slowly(z zone, delay time.Duration) handler {
+	return func(query *message, tcp bool) *message {
+		if query.questions[0].name == "slow.test." {
+			time.Sleep(delay)
+		}
+		return z.answer(query, tcp)
+	}
+}
+
+func TestTLSTransport(t *testing.T) {
+	defer leaktest.Check(t)()
+	serverConfig, clientConfig := newTLSConfigs()
+
+	Convey("When lookups are made over TLS, one connection is kept open, and reused", t, func() {
+		s := newFakeTLSServer(testZone.answer, serverConfig)
+		defer s.close()
+		c := newTestClient(Config{Protocol: ProtocolTLS, TLSConfig: clientConfig}, s)
+		defer c.Close()
+
+		for range 3 {
+			ips, ttl, err := c.LookupIPTTL(context.Background(), "a.test")
+			So(err, ShouldBeNil)
+			So(ips, ShouldResemble, []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")})
+			So(ttl, ShouldEqual, 30*time.Second)
+		}
+		So(s.accepted.Load(), ShouldEqual, 1)
+		So(s.tcpQueries.Load(), ShouldEqual, 6)
+	})
+
+	Convey("When queries are pipelined over TLS, answers are matched to them in whatever order they come", t, func() {
+		z := zone{"slow.test.": {a("slow.test.", "192.0.2.8", 60)}, "a.test.": testZone["a.test."]}
+		s := newFakeTLSServer(slowly(z, 200*time.Millisecond), serverConfig)
+		defer s.close()
+		c := newTestClient(Config{Protocol: ProtocolTLS, TLSConfig: clientConfig, Timeout: time.Second}, s)
+		defer c.Close()
+
+		_, err := c.LookupIP(context.Background(), "a.test") // open the connection
+		So(err, ShouldBeNil)
+
+		slow := make(chan []net.IP, 1)
+		go func() {
+			ips, _ := c.LookupIP(context.Background(), "slow.test")
+			slow <- ips
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		start := time.Now()
+		ips, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(ips, ShouldHaveLength, 2)
+		So(time.Since(start), ShouldBeLessThan, 150*time.Millisecond)
+		So(<-slow, ShouldResemble, []net.IP{net.ParseIP("192.0.2.8")})
+		So(s.accepted.Load(), ShouldEqual, 1)
+	})
+
+	Convey("When a TLS connection is idle for the IdleTimeout, it is closed, and another opened as needed", t, func() {
+		s := newFakeTLSServer(testZone.answer, serverConfig)
+		defer s.close()
+		c := newTestClient(Config{Protocol: ProtocolTLS, TLSConfig: clientConfig, IdleTimeout: 20 * time.Millisecond}, s)
+		defer c.Close()
+
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		time.Sleep(60 * time.Millisecond)
+		_, err = c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(s.accepted.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When the server closes the TLS connection, the Client reconnects", t, func() {
+		s := newFakeTLSServer(testZone.answer, serverConfig)
+		defer s.close()
+		c := newTestClient(Config{Protocol: ProtocolTLS, TLSConfig: clientConfig, Retries: 1}, s)
+		defer c.Close()
+
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		s.lock.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.lock.Unlock()
+
+		_, err = c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(s.accepted.Load(), ShouldEqual, 2)
+	})
+
+	Convey("When the Client is closed, its connections are, but it may still be used", t, func() {
+		s := newFakeTLSServer(testZone.answer, serverConfig)
+		defer s.close()
+		c := newTestClient(Config{Protocol: ProtocolTLS, TLSConfig: clientConfig}, s)
+
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(c.Close(), ShouldBeNil)
+		_, err = c.LookupIP(context.Background(), "a.test")
+		So(err, ShouldBeNil)
+		So(s.accepted.Load(), ShouldEqual, 2)
+		So(c.Close(), ShouldBeNil)
+	})
+
+	Convey("When a server is slow to handshake, connections to the others are not held up by it", t, func() {
+		s := newFakeTLSServer(testZone.answer, serverConfig)
+		defer s.close()
+		l, err := net.Listen("tcp", "127.0.0.1:0") // never accepts, so never handshakes
+		So(err, ShouldBeNil)
+		defer l.Close()
+
+		tr := newTLSTransport(clientConfig, time.Minute)
+		defer tr.close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		hung := make(chan error, 1)
+		go func() {
+			_, err := tr.conn(ctx, l.Addr().String())
+			hung <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		start := time.Now()
+		_, err = tr.conn(context.Background(), s.addr())
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, 150*time.Millisecond)
+		So(<-hung, ShouldNotBeNil)
+	})
+
+	Convey("When the transport is closed while a connection is being dialed, the connection is not kept", t, func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		release := make(chan struct{})
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			<-release // only handshake once the transport is closed
+			tc := tls.Server(conn, serverConfig)
+			tc.Handshake()
+			accepted <- tc
+		}()
+
+		tr := newTLSTransport(clientConfig, time.Minute)
+		dialed := make(chan error, 1)
+		go func() {
+			_, err := tr.conn(context.Background(), l.Addr().String())
+			dialed <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		tr.close()
+		close(release)
+		So(<-dialed, ShouldEqual, net.ErrClosed)
+		conn := <-accepted
+		defer conn.Close()
+
+		tr.lock.Lock()
+		So(tr.conns, ShouldBeEmpty)
+		tr.lock.Unlock()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		So(err, ShouldNotBeNil) // closed by the transport, rather than timed out
+		var ne net.Error
+		So(errors.As(err, &ne) && ne.Timeout(), ShouldBeFalse)
+	})
+
+	Convey("When the server's certificate is not trusted, the lookup fails", t, func() {
+		s := newFakeTLSServer(testZone.answer, serverConfig)
+		defer s.close()
+		c := newTestClient(Config{Protocol: ProtocolTLS}, s)
+		defer c.Close()
+
+		_, err := c.LookupIP(context.Background(), "a.test")
+		So(isDNSError(err), ShouldNotBeNil)
+		So(isDNSError(err).IsNotFound, ShouldBeFalse)
+		So(s.tcpQueries.Load(), ShouldBeZeroValue)
+	})
+}
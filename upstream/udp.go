@@ -0,0 +1,115 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// udpTransport sends queries over UDP, retrying over TCP if the answer is truncated.
+// A connection is dialed per query.
+type udpTransport struct {
+	udpSize int // the largest UDP answer accepted, if over 512
+}
+
+// exchange sends the query to the server, and returns the answer.
+func (t *udpTransport) exchange(ctx context.Context, server string, query *message) (*message, error) {
+	query.id = uint16(rand.Uint32())
+	b, err := query.pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.exchangeUDP(ctx, server, query, b)
+	if err == nil && resp.truncated {
+		resp, err = t.exchangeTCP(ctx, server, query, b)
+	}
+	return resp, err
+}
+
+// close does nothing, as no connections are kept.
+func (t *udpTransport) close() {}
+
+// exchangeUDP sends the packed query to the server over UDP, and returns the first answer to it.
+// Datagrams that are not answers to the query are ignored.
+func (t *udpTransport) exchangeUDP(ctx context.Context, server string, query *message, b []byte) (*message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer deadlines(ctx, conn)()
+
+	if _, err = conn.Write(b); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, max(t.udpSize, minUDPSize))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err := parseMessage(buf[:n]); err == nil && answers(query, resp) {
+			return resp, nil
+		}
+	}
+}
+
+// exchangeTCP sends the packed query to the server over TCP, and returns the answer.
+func (t *udpTransport) exchangeTCP(ctx context.Context, server string, query *message, b []byte) (*message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer deadlines(ctx, conn)()
+
+	if err = writeFramed(conn, b); err != nil {
+		return nil, err
+	}
+	resp, err := readFramed(conn)
+	if err != nil {
+		return nil, err
+	}
+	if !answers(query, resp) {
+		return nil, errMismatch
+	}
+	return resp, nil
+}
+
+// errMismatch is returned when a stream answer is not the answer to the query.
+var errMismatch = errors.New("dns answer does not match the query")
+
+// deadlines applies the deadline of the Context to the connection, and expires it if the Context
+// is cancelled, until the returned func is called.
+func deadlines(ctx context.Context, conn net.Conn) func() bool {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+}
+
+// writeFramed writes the packed message to the stream, prefixed by its length, per RFC 1035 4.2.2.
+func writeFramed(w io.Writer, b []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+	return err
+}
+
+// readFramed reads a length-prefixed message from the stream.
+func readFramed(r io.Reader) (*message, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return parseMessage(buf)
+}
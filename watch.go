@@ -0,0 +1,156 @@
+package dnscache
+
+import (
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/cognusion/dnscache/cache"
+)
+
+// watch is one subscription to the IPs of an address.
+type watch struct {
+	ch   chan []net.IP
+	last []net.IP // the last set seen, for de-duplication
+	once sync.Once
+}
+
+// stop closes the channel, once.
+func (w *watch) stop() {
+	w.once.Do(func() { close(w.ch) })
+}
+
+// watchers is a goro-safe cache.Observer that pushes changed IP sets to the watches of their address.
+type watchers struct {
+	cache.NoopObserver
+
+	lock    sync.Mutex
+	watches map[string]map[*watch]struct{}
+	family  cache.Family
+	closed  bool
+}
+
+// newWatchers returns watchers that filter the IPs pushed by the Family.
+func newWatchers(family cache.Family) *watchers {
+	return &watchers{
+		watches: make(map[string]map[*watch]struct{}),
+		family:  family,
+	}
+}
+
+// add returns a new watch of the address, whose last set is that returned by current, and a func to cancel it.
+// current is called with the lock held, so that no set pushed meanwhile is missed.
+// If the watchers are closed, the watch is already stopped.
+func (ws *watchers) add(address string, current func() []net.IP) (*watch, func()) {
+	w := &watch{
+		ch: make(chan []net.IP, 1),
+	}
+
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if ws.closed {
+		w.stop()
+		return w, func() {}
+	}
+	w.last = ws.filter(current())
+
+	set, ok := ws.watches[address]
+	if !ok {
+		set = make(map[*watch]struct{})
+		ws.watches[address] = set
+	}
+	set[w] = struct{}{}
+
+	return w, func() {
+		ws.lock.Lock()
+		defer ws.lock.Unlock()
+
+		if set, ok := ws.watches[address]; ok {
+			delete(set, w)
+			if len(set) == 0 {
+				delete(ws.watches, address)
+			}
+		}
+		w.stop()
+	}
+}
+
+// filter returns the IPs of the Family, if one is set.
+func (ws *watchers) filter(ips []net.IP) []net.IP {
+	if ws.family == cache.FamilyAny {
+		return ips
+	}
+	return cache.FilterIPs(ws.family, ips...)
+}
+
+// push sends the IPs to each watch of the address whose last set differs.
+// If a watcher hasn't received the previous set, it is replaced, so the latest always wins.
+func (ws *watchers) push(address string, ips []net.IP) {
+	ips = ws.filter(ips)
+
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	for w := range ws.watches[address] {
+		if cache.SameIPs(w.last, ips) {
+			continue
+		}
+		w.last = ips
+
+		select {
+		case w.ch <- slices.Clone(ips):
+		default:
+			// full, so replace the unreceived set. We are the only sender.
+			select {
+			case <-w.ch:
+			default:
+			}
+			w.ch <- slices.Clone(ips)
+		}
+	}
+}
+
+// close stops every watch, and any added afterwards.
+func (ws *watchers) close() {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	ws.closed = true
+	for _, set := range ws.watches {
+		for w := range set {
+			w.stop()
+		}
+	}
+	ws.watches = make(map[string]map[*watch]struct{})
+}
+
+// EntryAdded pushes the IPs.
+func (ws *watchers) EntryAdded(address string, ips []net.IP) {
+	ws.push(address, ips)
+}
+
+// EntryChanged pushes the new IPs.
+func (ws *watchers) EntryChanged(address string, _, ips []net.IP) {
+	ws.push(address, ips)
+}
+
+// Watch returns a channel that receives the IPs of the address whenever a lookup, including
+// those made by Refresh, returns a different set than was last seen, and a func to cancel the watch.
+// Unchanged results, in any order, are not sent. If a set has not been received before the next,
+// only the latest is kept. The IPs are filtered, or ordered, according to the configured Family.
+// The channel is closed when the watch is canceled, or the Resolver is Closed.
+// If the cache is not a cache.ObservableCache, changes cannot be seen, and the channel is closed immediately.
+func (r *Resolver) Watch(address string) (<-chan []net.IP, func()) {
+	if r.unobserve == nil {
+		ch := make(chan []net.IP)
+		close(ch)
+		return ch, func() {}
+	}
+
+	w, cancel := r.watchers.add(address, func() []net.IP {
+		current, _ := r.cache.Get(address)
+		return current
+	})
+	return w.ch, cancel
+}
@@ -0,0 +1,111 @@
+package dnscache
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cognusion/dnscache/cache"
+	"github.com/fortytw2/leaktest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatch(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	Convey("When an address is watched, changed sets are pushed, and unchanged sets are not.", t, func() {
+		var fake fakeResolver
+		fake.answer.Store(stringsToIPs("1.1.2.3", "1.1.2.4"))
+
+		c, err := cache.NewSimple(
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverTTLFunc(fake.resolve)),
+			cache.NewConfigOption(cache.ConfigRefreshSleepTime, time.Duration(0)),
+		)
+		So(err, ShouldBeNil)
+		r := NewFromConfig(&ResolverConfig{Cache: c})
+		defer r.Close()
+
+		r.Fetch("something.viki.io")
+		ch, cancel := r.Watch("something.viki.io")
+		defer cancel()
+
+		fake.answer.Store(stringsToIPs("1.1.2.4", "1.1.2.3")) // same set, different order
+		r.Refresh()
+		So(ch, ShouldHaveLength, 0)
+
+		fake.answer.Store(stringsToIPs("1.1.2.5"))
+		r.Refresh()
+		So(<-ch, ShouldResemble, stringsToIPs("1.1.2.5"))
+
+		Convey("only the latest unreceived set is kept", func() {
+			fake.answer.Store(stringsToIPs("1.1.2.6"))
+			r.Refresh()
+			fake.answer.Store(stringsToIPs("1.1.2.7"))
+			r.Refresh()
+			So(<-ch, ShouldResemble, stringsToIPs("1.1.2.7"))
+			So(ch, ShouldHaveLength, 0)
+		})
+
+		Convey("other addresses are not pushed", func() {
+			r.Fetch("other.viki.io")
+			So(ch, ShouldHaveLength, 0)
+		})
+
+		Convey("canceling closes the channel", func() {
+			cancel()
+			_, ok := <-ch
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("closing the Resolver closes the channel", func() {
+			c2, _ := cache.NewSimple()
+			r2 := NewFromConfig(&ResolverConfig{Cache: c2})
+			ch2, cancel2 := r2.Watch("something.viki.io")
+			r2.Close()
+			cancel2() // safe
+			_, ok := <-ch2
+			So(ok, ShouldBeFalse)
+
+			ch3, _ := r2.Watch("something.viki.io")
+			_, ok = <-ch3
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("When an address is watched before it is cached, its first set is pushed.", t, func() {
+		var fake fakeResolver
+		fake.answer.Store(stringsToIPs("1.1.2.3", "2001:db8::1"))
+
+		c, err := cache.NewLRU(
+			cache.NewConfigOption(cache.ConfigSize, 5),
+			cache.NewConfigOption(cache.ConfigResolver, cache.ResolverTTLFunc(fake.resolve)),
+		)
+		So(err, ShouldBeNil)
+		r := NewFromConfig(&ResolverConfig{Cache: c, Family: cache.FamilyIPv6})
+		defer r.Close()
+
+		ch, cancel := r.Watch("something.viki.io")
+		defer cancel()
+
+		r.Fetch("something.viki.io")
+		So(<-ch, ShouldResemble, stringsToIPs("2001:db8::1")) // filtered by Family
+	})
+
+	Convey("When a set is pushed as an address is being watched, it is not missed.", t, func() {
+		ws := newWatchers(cache.FamilyAny)
+		defer ws.close()
+
+		pushed := make(chan struct{})
+		w, cancel := ws.add("something.viki.io", func() []net.IP {
+			go func() {
+				defer close(pushed)
+				ws.push("something.viki.io", stringsToIPs("1.1.2.4"))
+			}()
+			return stringsToIPs("1.1.2.3")
+		})
+		defer cancel()
+
+		<-pushed
+		So(<-w.ch, ShouldResemble, stringsToIPs("1.1.2.4"))
+	})
+}